@@ -2,12 +2,16 @@ package config
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
 	cfg "github.com/infinityworks/go-common/config"
@@ -21,41 +25,140 @@ type Config struct {
 	repositories            []string
 	organisations           []string
 	users                   []string
-	apiToken                string
+	apiTokens               []string
 	targetURLs              []string
+	targetNames             map[string]string
 	gitHubApp               bool
 	gitHubAppKeyPath        string
 	gitHubAppId             int64
 	gitHubAppInstallationId int64
 	gitHubRateLimit         float64
+	refreshInterval         time.Duration
+	collectors              map[string]bool
+	actionsLookback         time.Duration
+	actionsJobRuns          int
+	pullsLookback           time.Duration
+	issueLabels             []string
+	apiBackend              string
+	httpTimeout             time.Duration
+	maxConcurrentRequests   int
+	rateLimitReserve        float64
+	proxyURL                *url.URL
+	tlsCAs                  *x509.CertPool
+	tlsCertificates         []tls.Certificate
+	tlsInsecureSkipVerify   bool
+	transport               *http.Transport
+	name                    string
+	installation            string
+	gitHubAppTransport      *ghinstallation.Transport
+	webhookSecret           string
+}
+
+// The APIs repository data can be gathered from
+const (
+	BackendREST    = "rest"
+	BackendGraphQL = "graphql"
+)
+
+// Load returns the configuration of every target to be scraped. When CONFIG_FILE
+// is set the targets are read from that file, otherwise a single target is
+// configured from the environment by Init.
+func Load() ([]Config, error) {
+	configs := []Config{}
+	configFile := os.Getenv("CONFIG_FILE")
+	if configFile != "" {
+		loaded, err := LoadFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		configs = loaded
+	} else {
+		configs = append(configs, Init())
+	}
+	return expandInstallations(configs)
 }
 
 // Init populates the Config struct based on environmental runtime configuration
 func Init() Config {
 
-	listenPort := cfg.GetEnv("LISTEN_PORT", "9171")
-	os.Setenv("LISTEN_PORT", listenPort)
-	ac := cfg.Init()
-
-	appConfig := Config{
-		&ac,
-		nil,
-		nil,
-		nil,
-		nil,
-		"",
-		nil,
-		false,
-		"",
-		0,
-		0,
-		15000,
-	}
+	appConfig := newConfig(baseConfig())
 
 	err := appConfig.SetAPIURL(cfg.GetEnv("API_URL", "https://api.github.com"))
 	if err != nil {
 		log.Errorf("Error initialising Configuration. Unable to parse API URL. Error: %v", err)
 	}
+	err = appConfig.SetAPIBackend(cfg.GetEnv("API_BACKEND", BackendREST))
+	if err != nil {
+		log.Errorf("Error initialising Configuration. Error: %v", err)
+	}
+	err = appConfig.SetRefreshInterval(cfg.GetEnv("REFRESH_INTERVAL", "60s"))
+	if err != nil {
+		log.Errorf("Error initialising Configuration. Unable to parse refresh interval. Error: %v", err)
+	}
+	err = appConfig.SetActionsLookback(cfg.GetEnv("ACTIONS_LOOKBACK", "24h"))
+	if err != nil {
+		log.Errorf("Error initialising Configuration. Unable to parse Actions lookback. Error: %v", err)
+	}
+	err = appConfig.SetPullsLookback(cfg.GetEnv("PULLS_LOOKBACK", "168h"))
+	if err != nil {
+		log.Errorf("Error initialising Configuration. Unable to parse pull requests lookback. Error: %v", err)
+	}
+	appConfig.SetIssueLabels(strings.Split(os.Getenv("ISSUE_LABELS"), ","))
+	actionsJobRuns, err := strconv.Atoi(cfg.GetEnv("ACTIONS_JOB_RUNS", "10"))
+	if err != nil {
+		log.Errorf("Error initialising Configuration. Unable to parse Actions job runs. Error: %v", err)
+	} else {
+		appConfig.SetActionsJobRuns(actionsJobRuns)
+	}
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		name, ok := strings.CutPrefix(key, "COLLECTOR_")
+		if !ok {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Errorf("Error initialising Configuration. Unable to parse %s. Error: %v", key, err)
+			continue
+		}
+		appConfig.SetCollector(strings.ToLower(name), enabled)
+	}
+	err = appConfig.SetHTTPTimeout(cfg.GetEnv("HTTP_TIMEOUT", "10s"))
+	if err != nil {
+		log.Errorf("Error initialising Configuration. Unable to parse HTTP timeout. Error: %v", err)
+	}
+	maxConcurrentRequests, err := strconv.Atoi(cfg.GetEnv("MAX_CONCURRENT_REQUESTS", "10"))
+	if err == nil {
+		err = appConfig.SetMaxConcurrentRequests(maxConcurrentRequests)
+	}
+	if err != nil {
+		log.Errorf("Error initialising Configuration. Unable to parse max concurrent requests. Error: %v", err)
+	}
+	if proxyURL := os.Getenv("PROXY_URL"); proxyURL != "" {
+		if err := appConfig.SetProxyURL(proxyURL); err != nil {
+			log.Errorf("Error initialising Configuration. Unable to parse proxy URL. Error: %v", err)
+		}
+	}
+	if caFile := os.Getenv("TLS_CA_FILE"); caFile != "" {
+		if err := appConfig.SetTLSCAFile(caFile); err != nil {
+			log.Errorf("Error initialising Configuration. Unable to load CA bundle. Error: %v", err)
+		}
+	}
+	if certFile := os.Getenv("TLS_CERT_FILE"); certFile != "" {
+		if err := appConfig.SetTLSClientCertificate(certFile, os.Getenv("TLS_KEY_FILE")); err != nil {
+			log.Errorf("Error initialising Configuration. Unable to load client certificate. Error: %v", err)
+		}
+	}
+	if strings.ToLower(os.Getenv("TLS_INSECURE_SKIP_VERIFY")) == "true" {
+		appConfig.SetTLSInsecureSkipVerify(true)
+	}
+	rateLimitReserve, err := strconv.ParseFloat(cfg.GetEnv("RATE_LIMIT_RESERVE", "0"), 64)
+	if err == nil {
+		err = appConfig.SetRateLimitReserve(rateLimitReserve)
+	}
+	if err != nil {
+		log.Errorf("Error initialising Configuration. Unable to parse rate limit reserve. Error: %v", err)
+	}
 	repos := os.Getenv("REPOS")
 	if repos != "" {
 		appConfig.SetRepositories(strings.Split(repos, ", "))
@@ -80,10 +183,7 @@ func Init() Config {
 		appConfig.SetGitHubAppId(gitHubAppId)
 		appConfig.SetGitHubAppInstallationId(gitHubAppInstallationId)
 		appConfig.SetGitHubRateLimit(gitHubRateLimit)
-		err = appConfig.SetAPITokenFromGitHubApp()
-		if err != nil {
-			log.Errorf("Error initializing Configuration, Error: %v", err)
-		}
+		// The installation token is obtained by the first refresh, see RenewGitHubAppToken
 	}
 
 	tokenEnv := os.Getenv("GITHUB_TOKEN")
@@ -99,19 +199,87 @@ func Init() Config {
 	return appConfig
 }
 
+// baseConfig reads the application wide settings shared by every target from the environment
+func baseConfig() *cfg.BaseConfig {
+	listenPort := cfg.GetEnv("LISTEN_PORT", "9171")
+	os.Setenv("LISTEN_PORT", listenPort)
+	ac := cfg.Init()
+	return &ac
+}
+
+// newConfig returns a Config holding the default settings, and the webhook secret
+// which like the listen port is read from the environment even with a configuration file
+func newConfig(base *cfg.BaseConfig) Config {
+	return Config{
+		BaseConfig:            base,
+		gitHubRateLimit:       15000,
+		refreshInterval:       time.Minute,
+		actionsLookback:       24 * time.Hour,
+		actionsJobRuns:        10,
+		pullsLookback:         7 * 24 * time.Hour,
+		apiBackend:            BackendREST,
+		httpTimeout:           10 * time.Second,
+		maxConcurrentRequests: 10,
+		webhookSecret:         os.Getenv("WEBHOOK_SECRET"),
+	}
+}
+
+// Returns the name of the target as given in the configuration file, empty when configured from the environment.
+// Targets discovered from the installations of a GitHub App are named after the installation account.
+func (c *Config) Name() string {
+	return c.name
+}
+
 // Returns the base APIURL
 func (c *Config) APIURL() *url.URL {
 	return c.apiUrl
 }
 
+// Returns the configured list of repositories
+func (c *Config) Repositories() []string {
+	return c.repositories
+}
+
+// Returns the configured list of organisations
+func (c *Config) Organisations() []string {
+	return c.organisations
+}
+
+// Returns the configured list of users
+func (c *Config) Users() []string {
+	return c.users
+}
+
 // Returns a list of all object URLs to scrape
 func (c *Config) TargetURLs() []string {
 	return c.targetURLs
 }
 
-// Returns the oauth2 token for usage in http.request
+// Returns the name of the repository, organisation, user or App installation a target URL
+// was built from, in the form "repo:owner/name", "org:name", "user:name" or "installation:account"
+func (c *Config) TargetName(url string) string {
+	if name, ok := c.targetNames[url]; ok {
+		return name
+	}
+	return url
+}
+
+// Returns the API repository data is gathered from, either BackendREST or BackendGraphQL
+func (c *Config) APIBackend() string {
+	return c.apiBackend
+}
+
+// Returns the oauth2 token for usage in http.request, the first when several are configured
 func (c *Config) APIToken() string {
-	return c.apiToken
+	if len(c.apiTokens) == 0 {
+		return ""
+	}
+	return c.apiTokens[0]
+}
+
+// Returns every configured oauth2 token, among which requests are spread
+func (c *Config) APITokens() []string {
+	return c.apiTokens
 }
 
 // Returns the GitHub App authentication value
@@ -135,10 +303,60 @@ func (c *Config) GitHubAppInstallationId() int64 {
 }
 
 // Returns the GitHub RateLimit
+//
+// Deprecated: App tokens are renewed before they expire, without consulting the rate limit.
 func (c *Config) GitHubRateLimit() float64 {
 	return c.gitHubRateLimit
 }
 
+// Returns the secret webhook deliveries are signed with, the webhook endpoint is disabled when empty
+func (c *Config) WebhookSecret() string {
+	return c.webhookSecret
+}
+
+// Returns the number of requests left unused before the rate limit resets
+func (c *Config) RateLimitReserve() float64 {
+	return c.rateLimitReserve
+}
+
+// Returns the interval at which the GitHub API is polled in the background
+func (c *Config) RefreshInterval() time.Duration {
+	return c.refreshInterval
+}
+
+// Returns whether the named collector is enabled, or the supplied default if it has not been configured
+func (c *Config) CollectorEnabled(name string, fallback bool) bool {
+	if enabled, ok := c.collectors[name]; ok {
+		return enabled
+	}
+	return fallback
+}
+
+// Returns the collectors which have been explicitly enabled or disabled
+func (c *Config) Collectors() map[string]bool {
+	return c.collectors
+}
+
+// Returns how far back GitHub Actions workflow runs are considered
+func (c *Config) ActionsLookback() time.Duration {
+	return c.actionsLookback
+}
+
+// Returns how far back closed pull requests are considered
+func (c *Config) PullsLookback() time.Duration {
+	return c.pullsLookback
+}
+
+// Returns the labels issues are counted by, bounding the cardinality of the issue metrics
+func (c *Config) IssueLabels() []string {
+	return c.issueLabels
+}
+
+// Returns the number of most recent workflow runs per repository whose jobs are fetched
+func (c *Config) ActionsJobRuns() int {
+	return c.actionsJobRuns
+}
+
 // Sets the base API URL returning an error if the supplied string is not a valid URL
 func (c *Config) SetAPIURL(u string) error {
 	ur, err := url.Parse(u)
@@ -146,6 +364,77 @@ func (c *Config) SetAPIURL(u string) error {
 	return err
 }
 
+// Sets the API repository data is gathered from returning an error if it is not BackendREST or BackendGraphQL
+func (c *Config) SetAPIBackend(backend string) error {
+	switch backend {
+	case BackendREST, BackendGraphQL:
+		c.apiBackend = backend
+		return nil
+	}
+	return fmt.Errorf("unknown API backend %q, expected %q or %q", backend, BackendREST, BackendGraphQL)
+}
+
+// Sets the background refresh interval returning an error if the supplied string is not a positive duration
+func (c *Config) SetRefreshInterval(interval string) error {
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return fmt.Errorf("refresh interval must be positive, got %s", interval)
+	}
+	c.refreshInterval = d
+	return nil
+}
+
+// Sets the number of requests left unused before the rate limit resets returning an error if it is negative
+func (c *Config) SetRateLimitReserve(reserve float64) error {
+	if reserve < 0 {
+		return fmt.Errorf("rate limit reserve must not be negative, got %v", reserve)
+	}
+	c.rateLimitReserve = reserve
+	return nil
+}
+
+// SetCollector enables or disables the named collector
+func (c *Config) SetCollector(name string, enabled bool) {
+	if c.collectors == nil {
+		c.collectors = map[string]bool{}
+	}
+	c.collectors[name] = enabled
+}
+
+// Sets the Actions lookback window returning an error if the supplied string is not a positive duration
+func (c *Config) SetActionsLookback(lookback string) error {
+	d, err := time.ParseDuration(lookback)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return fmt.Errorf("actions lookback must be positive, got %s", lookback)
+	}
+	c.actionsLookback = d
+	return nil
+}
+
+// Sets the closed pull requests lookback window returning an error if the supplied string is not a positive duration
+func (c *Config) SetPullsLookback(lookback string) error {
+	d, err := time.ParseDuration(lookback)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return fmt.Errorf("pull requests lookback must be positive, got %s", lookback)
+	}
+	c.pullsLookback = d
+	return nil
+}
+
+// SetActionsJobRuns accepts the number of recent workflow runs whose jobs are fetched
+func (c *Config) SetActionsJobRuns(actionsJobRuns int) {
+	c.actionsJobRuns = actionsJobRuns
+}
+
 // Overrides the entire list of repositories
 func (c *Config) SetRepositories(repos []string) {
 	c.repositories = repos
@@ -164,18 +453,60 @@ func (c *Config) SetUsers(users []string) {
 	c.setScrapeURLs()
 }
 
+// SetTarget replaces the repositories, organisations and users to scrape with the single target
+// given as "repo:owner/name", "org:name" or "user:name", returning an error if it is malformed
+func (c *Config) SetTarget(target string) error {
+	kind, name, _ := strings.Cut(target, ":")
+	owner, repo, isRepo := strings.Cut(name, "/")
+
+	switch {
+	case kind == "repo" && isRepo && owner != "" && repo != "":
+		c.repositories, c.organisations, c.users = []string{name}, nil, nil
+	case kind == "org" && !isRepo && name != "":
+		c.repositories, c.organisations, c.users = nil, []string{name}, nil
+	case kind == "user" && !isRepo && name != "":
+		c.repositories, c.organisations, c.users = nil, nil, []string{name}
+	default:
+		return fmt.Errorf("target must be given as repo:owner/name, org:name or user:name, got %q", target)
+	}
+
+	c.installation = ""
+	return c.setScrapeURLs()
+}
+
 // SetAPIToken accepts a string oauth2 token for usage in http.request
 func (c *Config) SetAPIToken(token string) {
-	c.apiToken = token
+	c.SetAPITokens([]string{token})
+}
+
+// SetAPITokens accepts several oauth2 tokens, ignoring empty ones
+func (c *Config) SetAPITokens(tokens []string) {
+	c.apiTokens = nil
+	for _, token := range tokens {
+		if token = strings.TrimSpace(token); token != "" {
+			c.apiTokens = append(c.apiTokens, token)
+		}
+	}
+}
+
+// Overrides the labels issues are counted by, ignoring surrounding whitespace and empty labels
+func (c *Config) SetIssueLabels(labels []string) {
+	c.issueLabels = nil
+	for _, label := range labels {
+		if label = strings.TrimSpace(label); label != "" {
+			c.issueLabels = append(c.issueLabels, label)
+		}
+	}
 }
 
-// SetAPITokenFromFile accepts a file containing an oauth2 token for usage in http.request
+// SetAPITokenFromFile accepts a file containing one or more oauth2 tokens, one per line, for usage in http.request
 func (c *Config) SetAPITokenFromFile(tokenFile string) error {
 	b, err := os.ReadFile(tokenFile)
 	if err != nil {
 		return err
 	}
-	c.apiToken = strings.TrimSpace(string(b))
+	// Each line of the file holds a token
+	c.SetAPITokens(strings.Split(string(b), "\n"))
 	return nil
 }
 
@@ -205,27 +536,78 @@ func (c *Config) SetGitHubRateLimit(gitHubRateLimit float64) {
 }
 
 // SetAPITokenFromGitHubApp generating api token from github app configuration.
+// The installation transport is kept, so that the token can be renewed before it expires.
 func (c *Config) SetAPITokenFromGitHubApp() error {
-	itr, err := ghinstallation.NewKeyFromFile(http.DefaultTransport, c.gitHubAppId, c.gitHubAppInstallationId, c.gitHubAppKeyPath)
+	var transport http.RoundTripper = http.DefaultTransport
+	if t := c.HTTPTransport(); t != nil {
+		transport = t
+	}
+	itr, err := ghinstallation.NewKeyFromFile(transport, c.gitHubAppId, c.gitHubAppInstallationId, c.gitHubAppKeyPath)
 	if err != nil {
 		return err
 	}
+	// Tokens are issued by the API being scraped, such as a GitHub Enterprise Server
+	itr.BaseURL = strings.TrimSuffix(c.apiUrl.String(), "/")
 	strToken, err := itr.Token(context.Background())
 	if err != nil {
 		return err
 	}
+	c.gitHubAppTransport = itr
 	c.SetAPIToken(strToken)
 	return nil
 }
 
+// RenewGitHubAppToken obtains a new installation token if the current one expires
+// within the supplied margin, or if no token has been obtained yet.
+func (c *Config) RenewGitHubAppToken(ctx context.Context, margin time.Duration) error {
+	if c.gitHubAppTransport == nil {
+		return c.SetAPITokenFromGitHubApp()
+	}
+
+	expiresAt, _, err := c.gitHubAppTransport.Expiry()
+	if err == nil && time.Until(expiresAt) > margin {
+		return nil
+	}
+
+	// The transport only renews the token in its final minute, so a new one is created
+	return c.SetAPITokenFromGitHubApp()
+}
+
+// Returns when the GitHub App installation token expires, or the zero time if none has been obtained
+func (c *Config) GitHubAppTokenExpiry() time.Time {
+	if c.gitHubAppTransport == nil {
+		return time.Time{}
+	}
+	expiresAt, _, err := c.gitHubAppTransport.Expiry()
+	if err != nil {
+		return time.Time{}
+	}
+	return expiresAt
+}
+
 // Init populates the Config struct based on environmental runtime configuration
 // All URL's are added to the TargetURL's string array
 func (c *Config) setScrapeURLs() error {
 
 	urls := []string{}
+	names := map[string]string{}
 
 	opts := map[string]string{"per_page": "100"} // Used to set the Github API to return 100 results per page (max)
 
+	// An installation of a GitHub App scrapes every repository it has been granted access to
+	if c.installation != "" {
+		y := *c.apiUrl
+		y.Path = path.Join(y.Path, "installation", "repositories")
+		q := y.Query()
+		for k, v := range opts {
+			q.Add(k, v)
+		}
+		y.RawQuery = q.Encode()
+		c.targetURLs = []string{y.String()}
+		c.targetNames = map[string]string{y.String(): "installation:" + c.installation}
+		return nil
+	}
+
 	if len(c.repositories) == 0 && len(c.organisations) == 0 && len(c.users) == 0 {
 		log.Info("No targets specified. Only rate limit endpoint will be scraped")
 	}
@@ -241,6 +623,7 @@ func (c *Config) setScrapeURLs() error {
 			}
 			y.RawQuery = q.Encode()
 			urls = append(urls, y.String())
+			names[y.String()] = "repo:" + x
 		}
 	}
 
@@ -256,6 +639,7 @@ func (c *Config) setScrapeURLs() error {
 			}
 			y.RawQuery = q.Encode()
 			urls = append(urls, y.String())
+			names[y.String()] = "org:" + x
 		}
 	}
 
@@ -269,10 +653,12 @@ func (c *Config) setScrapeURLs() error {
 			}
 			y.RawQuery = q.Encode()
 			urls = append(urls, y.String())
+			names[y.String()] = "user:" + x
 		}
 	}
 
 	c.targetURLs = urls
+	c.targetNames = names
 
 	return nil
 }
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the layout of the YAML configuration file
+type fileConfig struct {
+	Targets []fileTarget `yaml:"targets"`
+}
+
+// fileTarget describes a single GitHub API endpoint, the credentials used
+// against it and what to scrape from it
+type fileTarget struct {
+	Name             string          `yaml:"name"`
+	APIURL           string          `yaml:"api_url"`
+	APIBackend       string          `yaml:"api_backend"`
+	Token            string          `yaml:"token"`
+	TokenFile        string          `yaml:"token_file"`
+	Tokens           []string        `yaml:"tokens"`
+	GitHubApp        *fileGitHubApp  `yaml:"github_app"`
+	HTTP             fileHTTP        `yaml:"http"`
+	Repos            []string        `yaml:"repos"`
+	Orgs             []string        `yaml:"orgs"`
+	Users            []string        `yaml:"users"`
+	Collectors       map[string]bool `yaml:"collectors"`
+	RefreshInterval  string          `yaml:"refresh_interval"`
+	RateLimitReserve float64         `yaml:"rate_limit_reserve"`
+	ActionsLookback  string          `yaml:"actions_lookback"`
+	ActionsJobRuns   int             `yaml:"actions_job_runs"`
+	PullsLookback    string          `yaml:"pulls_lookback"`
+	IssueLabels      []string        `yaml:"issue_labels"`
+}
+
+type fileGitHubApp struct {
+	ID             int64   `yaml:"id"`
+	InstallationID int64   `yaml:"installation_id"`
+	KeyPath        string  `yaml:"key_path"`
+	RateLimit      float64 `yaml:"rate_limit"`
+}
+
+// fileHTTP configures the client used to reach a target's API
+type fileHTTP struct {
+	Timeout               string `yaml:"timeout"`
+	ProxyURL              string `yaml:"proxy_url"`
+	CAFile                string `yaml:"ca_file"`
+	CertFile              string `yaml:"cert_file"`
+	KeyFile               string `yaml:"key_file"`
+	InsecureSkipVerify    bool   `yaml:"insecure_skip_verify"`
+	MaxConcurrentRequests int    `yaml:"max_concurrent_requests"`
+}
+
+// LoadFile reads and validates the YAML configuration file at the given path,
+// returning the configuration of each target it describes
+func LoadFile(path string) ([]Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read configuration file: %v", err)
+	}
+
+	f := fileConfig{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("unable to parse configuration file %s: %v", path, err)
+	}
+
+	if len(f.Targets) == 0 {
+		return nil, fmt.Errorf("configuration file %s: no targets defined", path)
+	}
+
+	base := baseConfig()
+	names := map[string]bool{}
+	configs := []Config{}
+
+	for i, t := range f.Targets {
+		if len(f.Targets) > 1 && t.Name == "" {
+			return nil, fmt.Errorf("configuration file %s: target %d: a name is required when more than one target is defined", path, i+1)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("configuration file %s: target %d: duplicate name %q", path, i+1, t.Name)
+		}
+		names[t.Name] = true
+
+		c := newConfig(base)
+		if err := t.apply(&c); err != nil {
+			return nil, fmt.Errorf("configuration file %s: target %d (%s): %v", path, i+1, t.Name, err)
+		}
+		configs = append(configs, c)
+	}
+
+	return configs, nil
+}
+
+// apply validates the target and sets its values on the supplied Config
+func (t fileTarget) apply(c *Config) error {
+	c.name = t.Name
+
+	apiURL := t.APIURL
+	if apiURL == "" {
+		apiURL = "https://api.github.com"
+	}
+	if err := c.SetAPIURL(apiURL); err != nil {
+		return fmt.Errorf("invalid api_url: %v", err)
+	}
+	if c.APIURL().Scheme == "" || c.APIURL().Host == "" {
+		return fmt.Errorf("invalid api_url %q: an absolute URL is required", apiURL)
+	}
+
+	if t.APIBackend != "" {
+		if err := c.SetAPIBackend(t.APIBackend); err != nil {
+			return fmt.Errorf("invalid api_backend: %v", err)
+		}
+	}
+
+	if err := t.HTTP.apply(c); err != nil {
+		return err
+	}
+
+	if t.RefreshInterval != "" {
+		if err := c.SetRefreshInterval(t.RefreshInterval); err != nil {
+			return fmt.Errorf("invalid refresh_interval: %v", err)
+		}
+	}
+	if err := c.SetRateLimitReserve(t.RateLimitReserve); err != nil {
+		return fmt.Errorf("invalid rate_limit_reserve: %v", err)
+	}
+	if t.ActionsLookback != "" {
+		if err := c.SetActionsLookback(t.ActionsLookback); err != nil {
+			return fmt.Errorf("invalid actions_lookback: %v", err)
+		}
+	}
+	if t.PullsLookback != "" {
+		if err := c.SetPullsLookback(t.PullsLookback); err != nil {
+			return fmt.Errorf("invalid pulls_lookback: %v", err)
+		}
+	}
+	if t.ActionsJobRuns < 0 {
+		return fmt.Errorf("invalid actions_job_runs: must not be negative")
+	}
+	if t.ActionsJobRuns > 0 {
+		c.SetActionsJobRuns(t.ActionsJobRuns)
+	}
+
+	c.SetIssueLabels(t.IssueLabels)
+
+	for name, enabled := range t.Collectors {
+		c.SetCollector(name, enabled)
+	}
+
+	c.repositories = t.Repos
+	c.organisations = t.Orgs
+	c.users = t.Users
+	c.setScrapeURLs()
+
+	credentials := 0
+	for _, set := range []bool{t.Token != "", t.TokenFile != "", len(t.Tokens) > 0, t.GitHubApp != nil} {
+		if set {
+			credentials++
+		}
+	}
+	if credentials > 1 {
+		return errors.New("only one of token, tokens, token_file and github_app may be set")
+	}
+
+	switch {
+	case t.Token != "":
+		c.SetAPIToken(t.Token)
+	case len(t.Tokens) > 0:
+		c.SetAPITokens(t.Tokens)
+	case t.TokenFile != "":
+		if err := c.SetAPITokenFromFile(t.TokenFile); err != nil {
+			return fmt.Errorf("invalid token_file: %v", err)
+		}
+	case t.GitHubApp != nil:
+		if t.GitHubApp.ID == 0 || t.GitHubApp.KeyPath == "" {
+			return errors.New("github_app requires id and key_path")
+		}
+		rateLimit := t.GitHubApp.RateLimit
+		if rateLimit == 0 {
+			rateLimit = 15000
+		}
+		c.SetGitHubApp(true)
+		c.SetGitHubAppId(t.GitHubApp.ID)
+		c.SetGitHubAppInstallationId(t.GitHubApp.InstallationID)
+		c.SetGitHubAppKeyPath(t.GitHubApp.KeyPath)
+		c.SetGitHubRateLimit(rateLimit)
+		// The installation token is obtained by the first refresh, see RenewGitHubAppToken
+	}
+
+	return nil
+}
+
+// apply validates the HTTP settings and sets them on the supplied Config
+func (h fileHTTP) apply(c *Config) error {
+	if h.Timeout != "" {
+		if err := c.SetHTTPTimeout(h.Timeout); err != nil {
+			return fmt.Errorf("invalid http.timeout: %v", err)
+		}
+	}
+	if h.MaxConcurrentRequests != 0 {
+		if err := c.SetMaxConcurrentRequests(h.MaxConcurrentRequests); err != nil {
+			return fmt.Errorf("invalid http.max_concurrent_requests: %v", err)
+		}
+	}
+	if h.ProxyURL != "" {
+		if err := c.SetProxyURL(h.ProxyURL); err != nil {
+			return fmt.Errorf("invalid http.proxy_url: %v", err)
+		}
+	}
+	if h.CAFile != "" {
+		if err := c.SetTLSCAFile(h.CAFile); err != nil {
+			return fmt.Errorf("invalid http.ca_file: %v", err)
+		}
+	}
+	if (h.CertFile == "") != (h.KeyFile == "") {
+		return errors.New("http.cert_file and http.key_file must be set together")
+	}
+	if h.CertFile != "" {
+		if err := c.SetTLSClientCertificate(h.CertFile, h.KeyFile); err != nil {
+			return fmt.Errorf("invalid http.cert_file: %v", err)
+		}
+	}
+	c.SetTLSInsecureSkipVerify(h.InsecureSkipVerify)
+	return nil
+}
+
+// Watch calls onChange whenever the modification time of the configuration file
+// named by CONFIG_FILE changes, checking every interval until the context is
+// cancelled. It returns immediately if no configuration file is in use.
+func Watch(ctx context.Context, interval time.Duration, onChange func()) {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return
+	}
+
+	modTime := func() time.Time {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Errorf("Unable to check configuration file for changes, Error: %v", err)
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+
+	last := modTime()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if current := modTime(); !current.IsZero() && !current.Equal(last) {
+				last = current
+				onChange()
+			}
+		}
+	}
+}
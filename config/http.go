@@ -0,0 +1,125 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Returns how long a single request to the API may take
+func (c *Config) HTTPTimeout() time.Duration {
+	return c.httpTimeout
+}
+
+// Returns how many requests to the API may be in flight at once
+func (c *Config) MaxConcurrentRequests() int {
+	return c.maxConcurrentRequests
+}
+
+// HTTPTransport returns the transport applying the configured proxy and TLS settings,
+// or nil if none are configured, in which case http.DefaultTransport should be used.
+// It is built once and shared by every copy of the config, so that they share its connections.
+func (c *Config) HTTPTransport() *http.Transport {
+	return c.transport
+}
+
+// setTransport rebuilds the transport for the proxy and TLS settings, closing the idle
+// connections of the one it replaces. Without an explicit proxy, the proxy is taken from
+// HTTPS_PROXY and NO_PROXY.
+func (c *Config) setTransport() {
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
+		c.transport = nil
+	}
+	if c.proxyURL == nil && c.tlsCAs == nil && len(c.tlsCertificates) == 0 && !c.tlsInsecureSkipVerify {
+		return
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if c.proxyURL != nil {
+		t.Proxy = http.ProxyURL(c.proxyURL)
+	}
+	t.TLSClientConfig = &tls.Config{
+		RootCAs:            c.tlsCAs,
+		Certificates:       c.tlsCertificates,
+		InsecureSkipVerify: c.tlsInsecureSkipVerify,
+	}
+	c.transport = t
+}
+
+// Sets the timeout of a single request to the API returning an error if the supplied string is not a positive duration
+func (c *Config) SetHTTPTimeout(timeout string) error {
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return fmt.Errorf("HTTP timeout must be positive, got %s", timeout)
+	}
+	c.httpTimeout = d
+	return nil
+}
+
+// Sets how many requests to the API may be in flight at once returning an error if it is not positive
+func (c *Config) SetMaxConcurrentRequests(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("max concurrent requests must be positive, got %d", n)
+	}
+	c.maxConcurrentRequests = n
+	return nil
+}
+
+// Sets the proxy requests to the API are sent through returning an error if the supplied string is not an absolute URL
+func (c *Config) SetProxyURL(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("an absolute proxy URL is required, got %q", proxyURL)
+	}
+	c.proxyURL = u
+	c.setTransport()
+	return nil
+}
+
+// SetTLSCAFile trusts the PEM encoded certificates in the file, such as the internal CA
+// of a GitHub Enterprise Server, in addition to the system certificates
+func (c *Config) SetTLSCAFile(caFile string) error {
+	b, err := os.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return fmt.Errorf("no certificates found in %s", caFile)
+	}
+	c.tlsCAs = pool
+	c.setTransport()
+	return nil
+}
+
+// SetTLSClientCertificate presents the PEM encoded certificate and key to the API
+func (c *Config) SetTLSClientCertificate(certFile string, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	c.tlsCertificates = []tls.Certificate{cert}
+	c.setTransport()
+	return nil
+}
+
+// SetTLSInsecureSkipVerify disables verification of the API's certificate, intended only for lab instances
+func (c *Config) SetTLSInsecureSkipVerify(insecure bool) {
+	c.tlsInsecureSkipVerify = insecure
+	c.setTransport()
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// installationsPerPage is the number of installations requested from the API at once (max)
+const installationsPerPage = 100
+
+// installation is an installation of a GitHub App, as listed by the /app/installations endpoint
+type installation struct {
+	ID      int64 `json:"id"`
+	Account struct {
+		Login string `json:"login"`
+	} `json:"account"`
+}
+
+// Returns whether the target authenticates as a GitHub App without an installation id,
+// in which case every installation of the App is discovered and scraped
+func (c *Config) DiscoversInstallations() bool {
+	return c.gitHubApp && c.gitHubAppInstallationId == 0
+}
+
+// Returns the account of the GitHub App installation the target was discovered from, empty otherwise
+func (c *Config) Installation() string {
+	return c.installation
+}
+
+// Installations lists the installations of the configured GitHub App, returning a Config
+// for each which scrapes the repositories accessible to the installation using its own token,
+// obtained by its first refresh, so that listing them again only costs the listing itself.
+// Each is named after the account the App is installed on, prefixed by the target name if any.
+func (c *Config) Installations() ([]Config, error) {
+	installations, err := c.listInstallations()
+	if err != nil {
+		return nil, err
+	}
+
+	configs := []Config{}
+	for _, i := range installations {
+		ic := *c
+		ic.gitHubAppInstallationId = i.ID
+		ic.installation = i.Account.Login
+		ic.name = i.Account.Login
+		if c.name != "" {
+			ic.name = c.name + "/" + i.Account.Login
+		}
+		ic.setScrapeURLs()
+		configs = append(configs, ic)
+	}
+
+	log.Infof("Discovered %d installations of GitHub App %d", len(configs), c.gitHubAppId)
+
+	return configs, nil
+}
+
+// listInstallations fetches every installation of the App, authenticating as the App itself
+func (c *Config) listInstallations() ([]installation, error) {
+	var transport http.RoundTripper = http.DefaultTransport
+	if t := c.HTTPTransport(); t != nil {
+		transport = t
+	}
+	atr, err := ghinstallation.NewAppsTransportKeyFromFile(transport, c.gitHubAppId, c.gitHubAppKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: atr, Timeout: c.httpTimeout}
+
+	installations := []installation{}
+	for page := 1; ; page++ {
+		u := *c.apiUrl
+		u.Path = path.Join(u.Path, "app", "installations")
+		q := u.Query()
+		q.Set("per_page", strconv.Itoa(installationsPerPage))
+		q.Set("page", strconv.Itoa(page))
+		u.RawQuery = q.Encode()
+
+		resp, err := client.Get(u.String())
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unable to list installations of GitHub App %d: received %s status", c.gitHubAppId, resp.Status)
+		}
+
+		batch := []installation{}
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, fmt.Errorf("unable to decode installations of GitHub App %d: %v", c.gitHubAppId, err)
+		}
+		installations = append(installations, batch...)
+
+		if len(batch) < installationsPerPage {
+			return installations, nil
+		}
+	}
+}
+
+// expandInstallations replaces each configuration discovering the installations
+// of a GitHub App with the configuration of every installation found
+func expandInstallations(configs []Config) ([]Config, error) {
+	expanded := []Config{}
+	for _, c := range configs {
+		if !c.DiscoversInstallations() {
+			expanded = append(expanded, c)
+			continue
+		}
+		installations, err := c.Installations()
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %v", c.Name(), err)
+		}
+		expanded = append(expanded, installations...)
+	}
+	return expanded, nil
+}
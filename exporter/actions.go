@@ -0,0 +1,260 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	neturl "net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// durationBuckets are the histogram buckets, in seconds, used for Actions durations
+var durationBuckets = []float64{30, 60, 120, 300, 600, 900, 1800, 3600, 7200}
+
+// queueBuckets are the histogram buckets, in seconds, used for Actions job queue times
+var queueBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// actionsCollector exposes the GitHub Actions workflow runs created within the lookback window
+// for repositories configured in REPOS
+type actionsCollector struct {
+	runs        *prometheus.Desc
+	runDuration *prometheus.Desc
+}
+
+func newActionsCollector() *actionsCollector {
+	return &actionsCollector{
+		runs: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "actions", "workflow_runs"),
+			"Number of GitHub Actions workflow runs created within the lookback window",
+			[]string{"repo", "user", "workflow", "branch", "event", "status", "conclusion"}, nil,
+		),
+		runDuration: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "actions", "workflow_run_duration_seconds"),
+			"Duration of completed GitHub Actions workflow runs created within the lookback window",
+			[]string{"repo", "user", "workflow"}, nil,
+		),
+	}
+}
+
+func (c *actionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.runs
+	ch <- c.runDuration
+}
+
+func (c *actionsCollector) Update(ctx context.Context, e *Exporter, s *Snapshot) error {
+	for _, d := range s.Data {
+		if !d.isRepoTarget() {
+			continue
+		}
+		if err := getWorkflowRuns(ctx, e, d, &d.WorkflowRuns); err != nil {
+			log.Errorf("Unable to obtain workflow runs for target %s, Error: %s", d.Target, err)
+			s.TargetUp[d.Target] = false
+		}
+	}
+	return nil
+}
+
+// carry keeps the workflow runs of the last refresh
+func (c *actionsCollector) carry(e *Exporter, prev Snapshot, s *Snapshot) {
+	carryData(prev, s, func(p, d *Datum) {
+		d.WorkflowRuns = p.WorkflowRuns
+	})
+}
+
+// Collect counts the workflow runs of each repository and observes their durations
+func (c *actionsCollector) Collect(s Snapshot, ch chan<- prometheus.Metric) {
+	type runKey struct {
+		workflow, branch, event, status, conclusion string
+	}
+
+	for _, x := range s.Data {
+		counts := map[runKey]float64{}
+		durations := map[string][]float64{}
+
+		for _, run := range x.WorkflowRuns {
+			counts[runKey{run.Name, run.HeadBranch, run.Event, run.Status, run.Conclusion}]++
+
+			if run.Status == "completed" && !run.RunStartedAt.IsZero() {
+				durations[run.Name] = append(durations[run.Name], run.UpdatedAt.Sub(run.RunStartedAt).Seconds())
+			}
+		}
+
+		for k, count := range counts {
+			ch <- prometheus.MustNewConstMetric(c.runs, prometheus.GaugeValue, count, x.Name, x.Owner.Login, k.workflow, k.branch, k.event, k.status, k.conclusion)
+		}
+
+		for workflow, observations := range durations {
+			ch <- newConstHistogram(c.runDuration, durationBuckets, observations, x.Name, x.Owner.Login, workflow)
+		}
+	}
+}
+
+// actionsJobsCollector exposes the queue and execution times of the jobs and steps of
+// the most recent workflow runs fetched by the actions collector
+type actionsJobsCollector struct {
+	queueDuration *prometheus.Desc
+	jobDuration   *prometheus.Desc
+	stepDuration  *prometheus.Desc
+}
+
+func newActionsJobsCollector() *actionsJobsCollector {
+	return &actionsJobsCollector{
+		queueDuration: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "actions", "job_queue_duration_seconds"),
+			"Time GitHub Actions jobs of recent workflow runs waited for a runner",
+			[]string{"repo", "user", "workflow", "job", "runner_labels"}, nil,
+		),
+		jobDuration: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "actions", "job_duration_seconds"),
+			"Execution time of completed GitHub Actions jobs of recent workflow runs",
+			[]string{"repo", "user", "workflow", "job", "runner_labels"}, nil,
+		),
+		stepDuration: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "actions", "step_duration_seconds"),
+			"Execution time of completed steps of GitHub Actions jobs of recent workflow runs",
+			[]string{"repo", "user", "workflow", "job", "step"}, nil,
+		),
+	}
+}
+
+func (c *actionsJobsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queueDuration
+	ch <- c.jobDuration
+	ch <- c.stepDuration
+}
+
+func (c *actionsJobsCollector) Update(ctx context.Context, e *Exporter, s *Snapshot) error {
+	for _, d := range s.Data {
+		if len(d.WorkflowRuns) == 0 {
+			continue
+		}
+		if err := getWorkflowJobs(ctx, e, d, d.WorkflowRuns); err != nil {
+			log.Errorf("Unable to obtain workflow jobs for target %s, Error: %s", d.Target, err)
+			s.TargetUp[d.Target] = false
+		}
+	}
+	return nil
+}
+
+// carry keeps the jobs of the last refresh for the workflow runs which were already fetched then.
+// Workflow runs carried forward by a skipped actions collector keep their jobs already.
+func (c *actionsJobsCollector) carry(e *Exporter, prev Snapshot, s *Snapshot) {
+	if !slices.Contains(s.Collectors, "actions") {
+		return
+	}
+	carryData(prev, s, func(p, d *Datum) {
+		jobs := map[int64][]WorkflowJob{}
+		for _, run := range p.WorkflowRuns {
+			jobs[run.ID] = run.Jobs
+		}
+		for n := range d.WorkflowRuns {
+			d.WorkflowRuns[n].Jobs = jobs[d.WorkflowRuns[n].ID]
+		}
+	})
+}
+
+// Collect observes the queue and execution times of the jobs and steps of recent workflow runs
+func (c *actionsJobsCollector) Collect(s Snapshot, ch chan<- prometheus.Metric) {
+	type jobKey struct {
+		workflow, job, labels string
+	}
+	type stepKey struct {
+		workflow, job, step string
+	}
+
+	for _, x := range s.Data {
+		queued := map[jobKey][]float64{}
+		executed := map[jobKey][]float64{}
+		steps := map[stepKey][]float64{}
+
+		for _, run := range x.WorkflowRuns {
+			for _, job := range run.Jobs {
+				labels := append([]string{}, job.Labels...)
+				sort.Strings(labels)
+				k := jobKey{run.Name, job.Name, strings.Join(labels, ",")}
+
+				// Times missing from the API are zero, and would otherwise be observed as centuries
+				if !job.StartedAt.IsZero() && !job.CreatedAt.IsZero() {
+					queued[k] = append(queued[k], job.StartedAt.Sub(job.CreatedAt).Seconds())
+				}
+				if job.Status == "completed" && !job.StartedAt.IsZero() && !job.CompletedAt.IsZero() {
+					executed[k] = append(executed[k], job.CompletedAt.Sub(job.StartedAt).Seconds())
+				}
+
+				for _, step := range job.Steps {
+					if step.Status == "completed" && !step.StartedAt.IsZero() && !step.CompletedAt.IsZero() {
+						sk := stepKey{run.Name, job.Name, step.Name}
+						steps[sk] = append(steps[sk], step.CompletedAt.Sub(step.StartedAt).Seconds())
+					}
+				}
+			}
+		}
+
+		for k, observations := range queued {
+			ch <- newConstHistogram(c.queueDuration, queueBuckets, observations, x.Name, x.Owner.Login, k.workflow, k.job, k.labels)
+		}
+		for k, observations := range executed {
+			ch <- newConstHistogram(c.jobDuration, durationBuckets, observations, x.Name, x.Owner.Login, k.workflow, k.job, k.labels)
+		}
+		for k, observations := range steps {
+			ch <- newConstHistogram(c.stepDuration, durationBuckets, observations, x.Name, x.Owner.Login, k.workflow, k.job, k.step)
+		}
+	}
+}
+
+// getWorkflowRuns fetches the Actions workflow runs created within the configured lookback window
+func getWorkflowRuns(ctx context.Context, e *Exporter, d *Datum, data *[]WorkflowRun) error {
+	since := time.Now().Add(-e.ActionsLookback()).UTC().Format(time.RFC3339)
+	runsURL := repoURL(e, d, "actions", "runs") + "?per_page=100&created=" + neturl.QueryEscape(">="+since)
+	runsResponse := e.asyncHTTPGets(ctx, []string{runsURL})
+
+	for _, r := range runsResponse {
+		if r.err != nil {
+			return r.err
+		}
+		page := WorkflowRuns{}
+		if err := json.Unmarshal(r.body, &page); err != nil {
+			return err
+		}
+		*data = append(*data, page.WorkflowRuns...)
+	}
+
+	return nil
+}
+
+// getWorkflowJobs fetches the jobs of the most recent workflow runs, as configured by ActionsJobRuns.
+// Runs are returned by the API newest first, so the leading runs are used.
+func getWorkflowJobs(ctx context.Context, e *Exporter, d *Datum, runs []WorkflowRun) error {
+	jobsURLs := []string{}
+	byURL := map[string]*WorkflowRun{}
+	for n := range runs {
+		if n >= e.ActionsJobRuns() {
+			break
+		}
+		jobsURL := repoURL(e, d, "actions", "runs", fmt.Sprint(runs[n].ID), "jobs") + "?per_page=100"
+		jobsURLs = append(jobsURLs, jobsURL)
+		byURL[jobsURL] = &runs[n]
+	}
+
+	jobsResponse := e.asyncHTTPGets(ctx, jobsURLs)
+
+	for _, r := range jobsResponse {
+		if r.err != nil {
+			return r.err
+		}
+		page := WorkflowJobs{}
+		if err := json.Unmarshal(r.body, &page); err != nil {
+			return err
+		}
+		run := byURL[r.target]
+		run.Jobs = append(run.Jobs, page.Jobs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package exporter
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// targetsCost is the key under which the requests made gathering the repositories of each target are recorded
+const targetsCost = "targets"
+
+// budget returns the number of requests which may be made before the rate limit
+// resets while keeping the configured reserve, along with when it resets. It
+// returns false if the rate limit has not been read, or is not enabled.
+func (e *Exporter) budget(rates *RateLimits, now time.Time) (float64, time.Time, bool) {
+	if rates == nil || rates.Limit == 0 {
+		return 0, time.Time{}, false
+	}
+
+	reset := time.Unix(int64(rates.Reset), 0)
+	remaining := rates.Remaining
+	if !now.Before(reset) {
+		remaining = rates.Limit
+	}
+
+	return remaining - e.RateLimitReserve(), reset, true
+}
+
+// cost returns the requests the last refresh made gathering targets and updating the named collectors.
+// Collectors which have not been updated yet are not known to cost anything.
+func (e *Exporter) cost(collectors []string) float64 {
+	cost := e.costs[targetsCost]
+	for _, name := range collectors {
+		cost += e.costs[name]
+	}
+	return cost
+}
+
+// planCollectors returns the enabled collectors to update in the next refresh. While the requests
+// they cost in the last refresh exceed the remaining budget, the lowest priority collectors are skipped.
+func (e *Exporter) planCollectors(enabled []string, rates *RateLimits) ([]string, []string) {
+	available, _, ok := e.budget(rates, time.Now())
+	if !ok || e.cost(enabled) <= available {
+		return enabled, nil
+	}
+
+	// Consider the lowest priority collectors first, most expensive first within a priority
+	candidates := []string{}
+	for _, name := range enabled {
+		if lookupPriority(name) > 0 {
+			candidates = append(candidates, name)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		pi, pj := lookupPriority(candidates[i]), lookupPriority(candidates[j])
+		if pi != pj {
+			return pi > pj
+		}
+		return e.costs[candidates[i]] > e.costs[candidates[j]]
+	})
+
+	skip := map[string]bool{}
+	cost := e.cost(enabled)
+	for _, name := range candidates {
+		if cost <= available {
+			break
+		}
+		skip[name] = true
+		cost -= e.costs[name]
+	}
+
+	run, skipped := []string{}, []string{}
+	for _, name := range enabled {
+		if skip[name] {
+			skipped = append(skipped, name)
+			continue
+		}
+		run = append(run, name)
+	}
+
+	log.Warnf("Skipping the %v collectors to stay within the rate limit, %.0f requests remain after the reserve", skipped, available)
+
+	return run, skipped
+}
+
+// nextInterval returns how long to wait before the next refresh. The configured interval is
+// stretched when refreshing at it would exhaust the budget before the rate limit resets, based
+// on the requests the last refresh made, waiting for the reset if not even one refresh remains.
+func (e *Exporter) nextInterval(s Snapshot) time.Duration {
+	interval := e.RefreshInterval()
+
+	now := time.Now()
+	available, reset, ok := e.budget(s.Rates, now)
+	cost := s.Requests
+	untilReset := reset.Sub(now)
+	if !ok || cost == 0 || untilReset <= 0 {
+		return interval
+	}
+
+	refreshes := math.Floor(available / cost)
+	if refreshes < 1 {
+		log.Warnf("Rate limit budget exhausted, postponing refresh until the rate limit resets in %s", untilReset.Round(time.Second))
+		return untilReset
+	}
+
+	if stretched := time.Duration(float64(untilReset) / refreshes); stretched > interval {
+		log.Infof("Stretching refresh interval to %s to stay within the rate limit", stretched.Round(time.Second))
+		return stretched
+	}
+
+	return interval
+}
@@ -0,0 +1,158 @@
+package exporter
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// responseCacheSize is the total size of the response bodies kept for conditional
+// requests. The least recently used responses are evicted once it is exceeded, as URLs
+// such as those filtering workflow runs by creation time change between refreshes,
+// and a body larger than it is not cached at all.
+const responseCacheSize = 64 << 20
+
+// httpCache holds the responses of GET requests carrying an ETag or Last-Modified
+// header, shared by every exporter. GitHub does not count 304 Not Modified
+// responses against the rate limit, so revalidating a cached response is free.
+// Responses are keyed by the token they were fetched with as well as their URL,
+// so that one token is never answered with what only another may see.
+var httpCache = newResponseCache(responseCacheSize)
+
+type responseCache struct {
+	mu sync.Mutex
+	// size bounds the total size in bytes of the cached bodies, which used holds
+	size    int
+	used    int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cachedResponse struct {
+	key          string
+	etag         string
+	lastModified string
+	header       http.Header
+	body         []byte
+}
+
+// responseCacheKey returns the key a response fetched from the url with the token is cached under
+func responseCacheKey(token string, url string) string {
+	return tokenFingerprint(token) + " " + url
+}
+
+func newResponseCache(size int) *responseCache {
+	return &responseCache{
+		size:    size,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// get returns the response cached under the key, if there is one
+func (c *responseCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cachedResponse)
+}
+
+// set caches the response under the key, or removes any previously cached response if it cannot be revalidated
+func (c *responseCache) set(key string, header http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+
+	etag, lastModified := header.Get("ETag"), header.Get("Last-Modified")
+	if (etag == "" && lastModified == "") || len(body) > c.size {
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cachedResponse{
+		key:          key,
+		etag:         etag,
+		lastModified: lastModified,
+		header:       header.Clone(),
+		body:         body,
+	})
+	c.used += len(body)
+
+	for c.used > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove evicts the cached response held by the element
+func (c *responseCache) remove(el *list.Element) {
+	r := c.lru.Remove(el).(*cachedResponse)
+	delete(c.entries, r.key)
+	c.used -= len(r.body)
+}
+
+// setConditionalHeaders asks the API to only return the resource if it has changed since it was cached
+func (r *cachedResponse) setConditionalHeaders(req *http.Request) {
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+	if r.lastModified != "" {
+		req.Header.Set("If-Modified-Since", r.lastModified)
+	}
+}
+
+// revalidate returns the response to use for a request made with the cached
+// response's validators. A 304 Not Modified response is replaced with the cached
+// response, carrying the headers of both so that rate limits remain current,
+// while any other successful response replaces the cached one under the key.
+func (c *responseCache) revalidate(key string, url string, cached *cachedResponse, resp *http.Response) (*http.Response, error) {
+	endpoint := apiEndpoint(url)
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		cacheHits.WithLabelValues(endpoint).Inc()
+
+		header := cached.header.Clone()
+		for k, v := range resp.Header {
+			header[k] = v
+		}
+
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(cached.body)),
+			ContentLength: int64(len(cached.body)),
+			Request:       resp.Request,
+		}, nil
+	}
+
+	cacheMisses.WithLabelValues(endpoint).Inc()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	// Read the body so that it can be both cached and returned
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	c.set(key, resp.Header, body)
+
+	return resp, nil
+}
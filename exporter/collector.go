@@ -0,0 +1,142 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/githubexporter/github-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is implemented by each area of metrics gathered from the GitHub API.
+// Update is called on every refresh to fetch data into the snapshot, while
+// Collect is called on every scrape to build metrics from the latest snapshot.
+type Collector interface {
+	// Describe sends the descriptors of every metric the collector can produce
+	Describe(ch chan<- *prometheus.Desc)
+	// Update fetches the collector's data into the snapshot. Targets which
+	// cannot be fetched are marked down in the snapshot rather than returned
+	// as an error, which is reserved for failures of the collector as a whole.
+	Update(ctx context.Context, e *Exporter, s *Snapshot) error
+	// Collect sends the collector's metrics built from the snapshot
+	Collect(s Snapshot, ch chan<- prometheus.Metric)
+}
+
+// carrier is implemented by collectors whose data can be carried forward from the previous
+// snapshot while they are skipped, so that their series keep their last values rather than vanish
+type carrier interface {
+	carry(e *Exporter, prev Snapshot, s *Snapshot)
+}
+
+// carryData calls carry with each repository in the snapshot which was also in the previous one
+func carryData(prev Snapshot, s *Snapshot, carry func(prev *Datum, d *Datum)) {
+	type key struct {
+		target, owner, name string
+	}
+
+	previous := map[key]*Datum{}
+	for _, d := range prev.Data {
+		previous[key{d.Target, d.Owner.Login, d.Name}] = d
+	}
+	for _, d := range s.Data {
+		if p, ok := previous[key{d.Target, d.Owner.Login, d.Name}]; ok {
+			carry(p, d)
+		}
+	}
+}
+
+// registeredCollector is a Collector available to be enabled by name.
+// When the rate limit budget is short, collectors with the highest priority
+// number are skipped first, while those with priority zero are never skipped.
+type registeredCollector struct {
+	name           string
+	defaultEnabled bool
+	priority       int
+	requires       []string
+	collector      Collector
+}
+
+// collectors lists every available collector, in the order they are updated.
+// Collectors reading data fetched by another must be listed after it.
+var collectors = []registeredCollector{
+	{name: "repo", defaultEnabled: true, priority: 0, collector: newRepoCollector()},
+	{name: "release", defaultEnabled: true, priority: 1, collector: newReleaseCollector()},
+	{name: "pull", defaultEnabled: true, priority: 1, collector: newPullCollector()},
+	{name: "pull_lifecycle", defaultEnabled: false, priority: 2, requires: []string{"pull"}, collector: newPullLifecycleCollector()},
+	{name: "pull_reviews", defaultEnabled: false, priority: 3, requires: []string{"pull_lifecycle"}, collector: newPullReviewsCollector()},
+	{name: "issues", defaultEnabled: false, priority: 3, collector: newIssuesCollector()},
+	{name: "actions", defaultEnabled: false, priority: 2, collector: newActionsCollector()},
+	{name: "actions_jobs", defaultEnabled: false, priority: 3, requires: []string{"actions"}, collector: newActionsJobsCollector()},
+	{name: "runners", defaultEnabled: false, priority: 2, collector: newRunnersCollector()},
+	{name: "traffic", defaultEnabled: false, priority: 3, collector: newTrafficCollector()},
+	{name: "rate", defaultEnabled: true, priority: 0, collector: newRateCollector()},
+}
+
+// lookupCollector returns the named collector, or nil if there is none
+func lookupCollector(name string) Collector {
+	for _, c := range collectors {
+		if c.name == name {
+			return c.collector
+		}
+	}
+	return nil
+}
+
+// enabledCollectors returns the names of the collectors enabled by the supplied configuration, in update order
+func enabledCollectors(c *config.Config) []string {
+	names := []string{}
+	for _, rc := range collectors {
+		if c.CollectorEnabled(rc.name, rc.defaultEnabled) {
+			names = append(names, rc.name)
+		}
+	}
+	return names
+}
+
+// collectorEnabled returns whether the named collector is enabled by the supplied configuration
+func collectorEnabled(c *config.Config, name string) bool {
+	return c.CollectorEnabled(name, lookupDefault(name))
+}
+
+// CheckCollectors returns an error if the configuration names a collector which
+// does not exist, or enables a collector without the collectors it requires
+func CheckCollectors(c config.Config) error {
+	for name := range c.Collectors() {
+		if lookupCollector(name) == nil {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+
+	for _, rc := range collectors {
+		if !c.CollectorEnabled(rc.name, rc.defaultEnabled) {
+			continue
+		}
+		for _, required := range rc.requires {
+			if !c.CollectorEnabled(required, lookupDefault(required)) {
+				return fmt.Errorf("the %s collector requires the %s collector", rc.name, required)
+			}
+		}
+	}
+
+	return nil
+}
+
+// lookupPriority returns the priority of the named collector
+func lookupPriority(name string) int {
+	for _, c := range collectors {
+		if c.name == name {
+			return c.priority
+		}
+	}
+	return 0
+}
+
+// lookupDefault returns whether the named collector is enabled by default
+func lookupDefault(name string) bool {
+	for _, c := range collectors {
+		if c.name == name {
+			return c.defaultEnabled
+		}
+	}
+	return false
+}
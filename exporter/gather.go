@@ -1,124 +1,76 @@
 package exporter
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"path"
-	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// gatherData - Collects the data from the API and stores into struct
-func (e *Exporter) gatherData() ([]*Datum, error) {
+// gatherData - Collects the repositories of every target from the API and stores into struct.
+// Targets are scraped independently, the returned map reports whether
+// each target, keyed by name, was scraped without error.
+func (e *Exporter) gatherData(ctx context.Context) ([]*Datum, map[string]bool) {
 
 	data := []*Datum{}
+	up := map[string]bool{}
 
-	responses, err := asyncHTTPGets(e.TargetURLs(), e.APIToken())
-
-	if err != nil {
-		return data, err
+	for _, url := range e.TargetURLs() {
+		up[e.TargetName(url)] = true
 	}
 
+	responses := e.asyncHTTPGets(ctx, e.TargetURLs())
+
 	for _, response := range responses {
 
+		target := e.TargetName(response.target)
+
+		if response.err != nil {
+			log.Errorf("Error scraping target %s, Error: %v", target, response.err)
+			up[target] = false
+			continue
+		}
+
 		// Github can at times present an array, or an object for the same data set.
 		// This code checks handles this variation.
-		if isArray(response.body) {
+		if strings.HasPrefix(target, "installation:") {
+			// The repositories of an App installation are wrapped in an object holding their total count
+			page := struct {
+				Repositories []*Datum `json:"repositories"`
+			}{}
+			json.Unmarshal(response.body, &page)
+			for _, d := range page.Repositories {
+				d.Target = target
+			}
+			data = append(data, page.Repositories...)
+		} else if isArray(response.body) {
 			ds := []*Datum{}
 			json.Unmarshal(response.body, &ds)
+			for _, d := range ds {
+				d.Target = target
+			}
 			data = append(data, ds...)
 		} else {
 			d := new(Datum)
-
-			// Get releases
-			if strings.Contains(response.url, "/repos/") {
-				getReleases(e, response.url, &d.Releases)
-			}
-			// Get PRs
-			if strings.Contains(response.url, "/repos/") {
-				getPRs(e, response.url, &d.Pulls)
-			}
 			json.Unmarshal(response.body, &d)
+			d.Target = target
 			data = append(data, d)
 		}
 
-		log.Infof("API data fetched for repository: %s", response.url)
+		log.Infof("API data fetched for target %s: %s", target, response.url)
 	}
 
-	//return data, rates, err
-	return data, nil
+	return data, up
 
 }
 
-// getRates obtains the rate limit data for requests against the github API.
-// Especially useful when operating without oauth and the subsequent lower cap.
-func (e *Exporter) getRates() (*RateLimits, error) {
+// repoURL returns the URL of a repository's API resource, built from the path elements supplied
+func repoURL(e *Exporter, d *Datum, elem ...string) string {
 	u := *e.APIURL()
-	u.Path = path.Join(u.Path, "rate_limit")
-
-	resp, err := getHTTPResponse(u.String(), e.APIToken())
-	if err != nil {
-		return &RateLimits{}, err
-	}
-	defer resp.Body.Close()
-
-	// Triggers if rate-limiting isn't enabled on private Github Enterprise installations
-	if resp.StatusCode == 404 {
-		return &RateLimits{}, fmt.Errorf("Rate Limiting not enabled in GitHub API")
-	}
-
-	limit, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Limit"), 64)
-
-	if err != nil {
-		return &RateLimits{}, err
-	}
-
-	rem, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Remaining"), 64)
-
-	if err != nil {
-		return &RateLimits{}, err
-	}
-
-	reset, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset"), 64)
-
-	if err != nil {
-		return &RateLimits{}, err
-	}
-
-	return &RateLimits{
-		Limit:     limit,
-		Remaining: rem,
-		Reset:     reset,
-	}, err
-
-}
-
-func getReleases(e *Exporter, url string, data *[]Release) {
-	i := strings.Index(url, "?")
-	baseURL := url[:i]
-	releasesURL := baseURL + "/releases"
-	releasesResponse, err := asyncHTTPGets([]string{releasesURL}, e.APIToken())
-
-	if err != nil {
-		log.Errorf("Unable to obtain releases from API, Error: %s", err)
-	}
-
-	json.Unmarshal(releasesResponse[0].body, &data)
-}
-
-func getPRs(e *Exporter, url string, data *[]Pull) {
-	i := strings.Index(url, "?")
-	baseURL := url[:i]
-	pullsURL := baseURL + "/pulls"
-	pullsResponse, err := asyncHTTPGets([]string{pullsURL}, e.APIToken())
-
-	if err != nil {
-		log.Errorf("Unable to obtain pull requests from API, Error: %s", err)
-	}
-
-	json.Unmarshal(pullsResponse[0].body, &data)
+	u.Path = path.Join(append([]string{u.Path, "repos", d.Owner.Login, d.Name}, elem...)...)
+	return u.String()
 }
 
 // isArray simply looks for key details that determine if the JSON response is an array or not.
@@ -0,0 +1,516 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"path"
+	"strings"
+
+	"github.com/githubexporter/github-exporter/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// graphQLBatchSize is the number of repositories requested in a single query
+	graphQLBatchSize = 100
+	// graphQLReleases and graphQLAssets bound the releases and assets fetched per repository,
+	// keeping a full batch within the node limit of the GraphQL API
+	graphQLReleases = 25
+	graphQLAssets   = 100
+)
+
+// graphQLRepositoryFragment selects the fields of a repository needed to build a Datum
+const graphQLRepositoryFragment = `
+fragment repository on Repository {
+  name
+  owner { login }
+  licenseInfo { key }
+  primaryLanguage { name }
+  isArchived
+  isPrivate
+  isFork
+  forkCount
+  stargazerCount
+  diskUsage
+  watchers { totalCount }
+  issues(states: OPEN) { totalCount }
+  pullRequests(states: OPEN) { totalCount }
+}
+`
+
+// graphQLDetailsFragment selects the releases of a repository, which are only
+// requested for repositories configured in REPOS
+const graphQLDetailsFragment = `
+fragment details on Repository {
+  releases(first: %d, orderBy: {field: CREATED_AT, direction: DESC}) @include(if: $releases) {
+    nodes {
+      name
+      tagName
+      releaseAssets(first: %d) {
+        nodes { name size downloadCount createdAt }
+      }
+    }
+  }
+}
+`
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []graphQLError             `json:"errors"`
+}
+
+type graphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path"`
+}
+
+// graphQLRepositories is a page of the repositories of an organisation or user
+type graphQLRepositories struct {
+	Repositories struct {
+		PageInfo struct {
+			HasNextPage bool   `json:"hasNextPage"`
+			EndCursor   string `json:"endCursor"`
+		} `json:"pageInfo"`
+		Nodes []graphQLRepository `json:"nodes"`
+	} `json:"repositories"`
+}
+
+type graphQLRepository struct {
+	Name  string `json:"name"`
+	Owner struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	LicenseInfo *struct {
+		Key string `json:"key"`
+	} `json:"licenseInfo"`
+	PrimaryLanguage *struct {
+		Name string `json:"name"`
+	} `json:"primaryLanguage"`
+	IsArchived     bool         `json:"isArchived"`
+	IsPrivate      bool         `json:"isPrivate"`
+	IsFork         bool         `json:"isFork"`
+	ForkCount      float64      `json:"forkCount"`
+	StargazerCount float64      `json:"stargazerCount"`
+	DiskUsage      float64      `json:"diskUsage"`
+	Watchers       graphQLCount `json:"watchers"`
+	Issues         graphQLCount `json:"issues"`
+	PullRequests   graphQLCount `json:"pullRequests"`
+	Releases       *struct {
+		Nodes []struct {
+			Name          string `json:"name"`
+			TagName       string `json:"tagName"`
+			ReleaseAssets struct {
+				Nodes []struct {
+					Name          string `json:"name"`
+					Size          int64  `json:"size"`
+					DownloadCount int32  `json:"downloadCount"`
+					CreatedAt     string `json:"createdAt"`
+				} `json:"nodes"`
+			} `json:"releaseAssets"`
+		} `json:"nodes"`
+	} `json:"releases"`
+}
+
+type graphQLCount struct {
+	TotalCount float64 `json:"totalCount"`
+}
+
+// graphQLResult holds the repositories gathered by one query, or the targets which failed
+type graphQLResult struct {
+	data   []*Datum
+	failed []string
+}
+
+// gatherGraphQLData - Collects the repositories of every target from the GraphQL API, producing
+// the same Datum values as gatherData. Individually configured repositories are batched into as
+// few queries as possible, and when enabled their open pull requests and releases are included,
+// so the pull and release collectors need not query the REST API. As with the REST API, at most
+// MaxConcurrentRequests queries are in flight at once.
+func (e *Exporter) gatherGraphQLData(ctx context.Context) ([]*Datum, map[string]bool) {
+
+	data := []*Datum{}
+	up := map[string]bool{}
+
+	releases := collectorEnabled(&e.Config, "release")
+	pulls := collectorEnabled(&e.Config, "pull")
+
+	ch := make(chan graphQLResult)
+	sem := make(chan struct{}, e.MaxConcurrentRequests())
+	queries := 0
+	query := func(q func() graphQLResult) {
+		queries++
+		go func() {
+			sem <- struct{}{}
+			result := q()
+			<-sem
+			ch <- result
+		}()
+	}
+
+	repos := e.Repositories()
+	for start := 0; start < len(repos); start += graphQLBatchSize {
+		end := start + graphQLBatchSize
+		if end > len(repos) {
+			end = len(repos)
+		}
+		batch := repos[start:end]
+		query(func() graphQLResult { return e.graphQLRepos(ctx, batch, releases, pulls) })
+	}
+	for _, org := range e.Organisations() {
+		query(func() graphQLResult { return e.graphQLOwnerRepos(ctx, "org:"+org, "organization", "", org, pulls) })
+	}
+	for _, user := range e.Users() {
+		query(func() graphQLResult {
+			return e.graphQLOwnerRepos(ctx, "user:"+user, "user", "ownerAffiliations: OWNER, ", user, pulls)
+		})
+	}
+
+	for _, r := range repos {
+		up["repo:"+r] = true
+	}
+	for _, o := range e.Organisations() {
+		up["org:"+o] = true
+	}
+	for _, u := range e.Users() {
+		up["user:"+u] = true
+	}
+
+	for i := 0; i < queries; i++ {
+		result := <-ch
+		data = append(data, result.data...)
+		for _, target := range result.failed {
+			up[target] = false
+		}
+	}
+
+	return data, up
+}
+
+// graphQLRepos fetches a batch of repositories configured in REPOS in a single query,
+// aliasing each repository so that one which cannot be found fails only its own target
+func (e *Exporter) graphQLRepos(ctx context.Context, repos []string, releases bool, pulls bool) graphQLResult {
+	result := graphQLResult{}
+
+	params := []string{"$releases: Boolean!"}
+	fields := []string{}
+	variables := map[string]interface{}{"releases": releases}
+	aliases := map[string]string{}
+
+	for i, repo := range repos {
+		target := "repo:" + repo
+		owner, name, ok := strings.Cut(repo, "/")
+		if !ok {
+			log.Errorf("Error scraping target %s, Error: repositories must be given as owner/name", target)
+			result.failed = append(result.failed, target)
+			continue
+		}
+		alias := fmt.Sprintf("r%d", i)
+		params = append(params, fmt.Sprintf("$owner%d: String!, $name%d: String!", i, i))
+		fields = append(fields, fmt.Sprintf("%s: repository(owner: $owner%d, name: $name%d) { ...repository ...details }", alias, i, i))
+		variables[fmt.Sprintf("owner%d", i)] = owner
+		variables[fmt.Sprintf("name%d", i)] = name
+		aliases[alias] = target
+	}
+
+	if len(aliases) == 0 {
+		return result
+	}
+
+	query := fmt.Sprintf("query(%s) {\n%s\n}\n", strings.Join(params, ", "), strings.Join(fields, "\n")) +
+		graphQLRepositoryFragment + fmt.Sprintf(graphQLDetailsFragment, graphQLReleases, graphQLAssets)
+
+	resp, err := e.graphQLQuery(ctx, query, variables)
+	if err != nil {
+		for _, target := range aliases {
+			log.Errorf("Error scraping target %s, Error: %v", target, err)
+			result.failed = append(result.failed, target)
+		}
+		return result
+	}
+
+	for _, qe := range resp.Errors {
+		alias := qe.alias()
+		if target, ok := aliases[alias]; ok {
+			log.Errorf("Error scraping target %s, Error: %s", target, qe.Message)
+			result.failed = append(result.failed, target)
+			delete(aliases, alias)
+		}
+	}
+
+	for alias, target := range aliases {
+		r := graphQLRepository{}
+		if err := json.Unmarshal(resp.Data[alias], &r); err != nil || r.Name == "" {
+			log.Errorf("Error scraping target %s, Error: repository missing from GraphQL response", target)
+			result.failed = append(result.failed, target)
+			continue
+		}
+		result.data = append(result.data, r.datum(target, pulls))
+		log.Infof("API data fetched for target %s from the GraphQL API", target)
+	}
+
+	return result
+}
+
+// graphQLOwnerRepos fetches every repository of an organisation or user, following pagination.
+// Their open pull requests are counted when requested, but as with the REST API their releases are not gathered.
+func (e *Exporter) graphQLOwnerRepos(ctx context.Context, target string, field string, args string, login string, pulls bool) graphQLResult {
+	result := graphQLResult{}
+
+	query := fmt.Sprintf(`query($login: String!, $cursor: String) {
+  owner: %s(login: $login) {
+    repositories(%sfirst: 100, after: $cursor) {
+      pageInfo { hasNextPage endCursor }
+      nodes { ...repository }
+    }
+  }
+}
+`, field, args) + graphQLRepositoryFragment
+
+	variables := map[string]interface{}{"login": login, "cursor": nil}
+
+	for {
+		resp, err := e.graphQLQuery(ctx, query, variables)
+		if err == nil && len(resp.Errors) > 0 {
+			err = fmt.Errorf("%s", resp.Errors[0].Message)
+		}
+		page := graphQLRepositories{}
+		if err == nil {
+			err = json.Unmarshal(resp.Data["owner"], &page)
+		}
+		if err != nil {
+			log.Errorf("Error scraping target %s, Error: %v", target, err)
+			return graphQLResult{failed: []string{target}}
+		}
+
+		for _, r := range page.Repositories.Nodes {
+			result.data = append(result.data, r.datum(target, pulls))
+		}
+
+		if !page.Repositories.PageInfo.HasNextPage {
+			break
+		}
+		variables["cursor"] = page.Repositories.PageInfo.EndCursor
+	}
+
+	log.Infof("API data fetched for target %s from the GraphQL API", target)
+
+	return result
+}
+
+// graphQLPullCounts counts the open pull requests of a batch of repositories in a single query,
+// as the REST API can only count them by listing every page. The targets of repositories which
+// could not be counted are returned.
+func (e *Exporter) graphQLPullCounts(ctx context.Context, data []*Datum) []string {
+	fields, failed := e.graphQLRepoFields(ctx, data, "pull requests", "pullRequests(states: OPEN) { totalCount }", nil, nil)
+
+	for d, raw := range fields {
+		r := graphQLRepository{}
+		if err := json.Unmarshal(raw, &r); err != nil {
+			log.Errorf("Unable to count pull requests of %s/%s for target %s, Error: %v", d.Owner.Login, d.Name, d.Target, err)
+			failed = append(failed, d.Target)
+			continue
+		}
+		d.OpenPulls, d.PullsCounted = r.PullRequests.TotalCount, true
+	}
+
+	return failed
+}
+
+// graphQLClosedIssueCounts counts the closed issues of a batch of repositories in a single query,
+// in total and with each of the labels, as the REST API can only count them by listing every page.
+// The counts are keyed by label, with the total under the empty label. The targets of repositories
+// which could not be counted are returned.
+func (e *Exporter) graphQLClosedIssueCounts(ctx context.Context, data []*Datum, labels []string) []string {
+	params := []string{}
+	selection := []string{"total: issues(states: CLOSED) { totalCount }"}
+	variables := map[string]interface{}{}
+	for i, label := range labels {
+		params = append(params, fmt.Sprintf("$label%d: String!", i))
+		selection = append(selection, fmt.Sprintf("l%d: issues(states: CLOSED, labels: [$label%d]) { totalCount }", i, i))
+		variables[fmt.Sprintf("label%d", i)] = label
+	}
+
+	fields, failed := e.graphQLRepoFields(ctx, data, "closed issues", strings.Join(selection, " "), params, variables)
+
+	for d, raw := range fields {
+		counts := map[string]graphQLCount{}
+		if err := json.Unmarshal(raw, &counts); err != nil {
+			log.Errorf("Unable to count closed issues of %s/%s for target %s, Error: %v", d.Owner.Login, d.Name, d.Target, err)
+			failed = append(failed, d.Target)
+			continue
+		}
+		d.ClosedIssues = map[string]float64{"": counts["total"].TotalCount}
+		for i, label := range labels {
+			d.ClosedIssues[label] = counts[fmt.Sprintf("l%d", i)].TotalCount
+		}
+	}
+
+	return failed
+}
+
+// graphQLRepoFields selects fields of a batch of repositories in a single query, aliasing each
+// repository so that one which cannot be queried fails only its own target. The parameters and
+// variables are shared by the selection of every repository. The selected fields are returned
+// for each repository, along with the targets of those which failed, logged as describing what.
+func (e *Exporter) graphQLRepoFields(ctx context.Context, data []*Datum, what string, selection string, params []string, variables map[string]interface{}) (map[*Datum]json.RawMessage, []string) {
+	fields := map[*Datum]json.RawMessage{}
+	failed := []string{}
+
+	params = append([]string{}, params...)
+	vars := map[string]interface{}{}
+	for k, v := range variables {
+		vars[k] = v
+	}
+	queries := []string{}
+	aliases := map[string]*Datum{}
+
+	for i, d := range data {
+		alias := fmt.Sprintf("r%d", i)
+		params = append(params, fmt.Sprintf("$owner%d: String!, $name%d: String!", i, i))
+		queries = append(queries, fmt.Sprintf("%s: repository(owner: $owner%d, name: $name%d) { %s }", alias, i, i, selection))
+		vars[fmt.Sprintf("owner%d", i)] = d.Owner.Login
+		vars[fmt.Sprintf("name%d", i)] = d.Name
+		aliases[alias] = d
+	}
+
+	if len(aliases) == 0 {
+		return fields, failed
+	}
+
+	query := fmt.Sprintf("query(%s) {\n%s\n}\n", strings.Join(params, ", "), strings.Join(queries, "\n"))
+
+	resp, err := e.graphQLQuery(ctx, query, vars)
+	if err != nil {
+		for _, d := range aliases {
+			log.Errorf("Unable to count %s of %s/%s for target %s, Error: %v", what, d.Owner.Login, d.Name, d.Target, err)
+			failed = append(failed, d.Target)
+		}
+		return fields, failed
+	}
+
+	for _, qe := range resp.Errors {
+		alias := qe.alias()
+		if d, ok := aliases[alias]; ok {
+			log.Errorf("Unable to count %s of %s/%s for target %s, Error: %s", what, d.Owner.Login, d.Name, d.Target, qe.Message)
+			failed = append(failed, d.Target)
+			delete(aliases, alias)
+		}
+	}
+
+	for alias, d := range aliases {
+		fields[d] = resp.Data[alias]
+	}
+
+	return fields, failed
+}
+
+// graphQLQuery posts a query to the GraphQL API. Errors reported within the response
+// are returned in it, as they may concern only part of the query.
+func (e *Exporter) graphQLQuery(ctx context.Context, query string, variables map[string]interface{}) (*graphQLResponse, error) {
+	body, err := json.Marshal(graphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.postHTTPResponse(ctx, graphQLURL(e.APIURL()), body)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching http response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error converting body to byte array: %v", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("Error: Received %s status from Github API", resp.Status)
+	}
+
+	r := &graphQLResponse{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("Error parsing GraphQL response: %v", err)
+	}
+
+	return r, nil
+}
+
+// graphQLURL returns the GraphQL endpoint for a REST API URL. GitHub Enterprise
+// serves the REST API under /api/v3 and the GraphQL API at /api/graphql.
+func graphQLURL(apiURL *neturl.URL) string {
+	u := *apiURL
+	u.Path = path.Join(strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/v3"), "graphql")
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+	return u.String()
+}
+
+// alias returns the top level field of the query an error relates to
+func (qe graphQLError) alias() string {
+	if len(qe.Path) == 0 {
+		return ""
+	}
+	alias, _ := qe.Path[0].(string)
+	return alias
+}
+
+// datum converts the repository to the Datum gathered from the REST API. As there, the open issue
+// count includes pull requests, and the open pull requests are counted only when requested.
+func (r graphQLRepository) datum(target string, pulls bool) *Datum {
+	d := &Datum{
+		Name:       r.Name,
+		Archived:   r.IsArchived,
+		Private:    r.IsPrivate,
+		Fork:       r.IsFork,
+		Forks:      r.ForkCount,
+		Stars:      r.StargazerCount,
+		OpenIssues: r.Issues.TotalCount + r.PullRequests.TotalCount,
+		Watchers:   r.Watchers.TotalCount,
+		Size:       r.DiskUsage,
+		Target:     target,
+	}
+	d.Owner.Login = r.Owner.Login
+	if r.LicenseInfo != nil {
+		d.License.Key = r.LicenseInfo.Key
+	}
+	if r.PrimaryLanguage != nil {
+		d.Language = r.PrimaryLanguage.Name
+	}
+	if pulls {
+		d.OpenPulls, d.PullsCounted = r.PullRequests.TotalCount, true
+	}
+
+	if r.Releases != nil {
+		for _, n := range r.Releases.Nodes {
+			release := Release{Name: n.Name, Tag: n.TagName}
+			for _, a := range n.ReleaseAssets.Nodes {
+				release.Assets = append(release.Assets, Asset{Name: a.Name, Size: a.Size, Downloads: a.DownloadCount, CreatedAt: a.CreatedAt})
+			}
+			d.Releases = append(d.Releases, release)
+		}
+	}
+
+	return d
+}
+
+// countsByGraphQL reports whether what the REST API can only count by listing every page, such as open
+// pull requests or closed issues, is counted through the GraphQL API. GraphQL rejects anonymous requests
+// and is rate limited separately, so it is only used when configured as the backend with a token.
+func countsByGraphQL(c *config.Config) bool {
+	return c.APIBackend() == config.BackendGraphQL && c.APIToken() != ""
+}
+
+// usesGraphQL reports whether repository data is gathered from the GraphQL API.
+// The repositories of a GitHub App installation can only be listed by the REST API.
+func usesGraphQL(c *config.Config) bool {
+	return c.APIBackend() == config.BackendGraphQL && c.Installation() == ""
+}
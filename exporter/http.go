@@ -1,13 +1,17 @@
 package exporter
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	neturl "net/url"
 	"strconv"
-	"time"
+	"strings"
+	"sync/atomic"
 
+	"github.com/githubexporter/github-exporter/config"
 	log "github.com/sirupsen/logrus"
 	"github.com/tomnomnom/linkheader"
 )
@@ -15,146 +19,254 @@ import (
 // RateLimitExceededStatus is the status response from github when the rate limit is exceeded.
 const RateLimitExceededStatus = "403 rate limit exceeded"
 
-func asyncHTTPGets(targets []string, token string) ([]*Response, error) {
-	// Expand targets by following GitHub pagination links
-	targets = paginateTargets(targets, token)
-
-	// Channels used to enable concurrent requests
-	ch := make(chan *Response, len(targets))
-
-	responses := []*Response{}
+// asyncHTTPGets fetches every page of the provided targets using a pool of at most
+// MaxConcurrentRequests workers. The first page of each target is fetched first, and
+// the remaining pages named by its Link header are queued as soon as it arrives.
+// Each response records the target it was paginated from, and any error is
+// stored on the response so that one failing target does not affect the others.
+func (e *Exporter) asyncHTTPGets(ctx context.Context, targets []string) []*Response {
+	type page struct {
+		url, target string
+	}
 
+	queue := []page{}
+	seen := map[string]bool{}
 	for _, url := range targets {
+		if !seen[url] {
+			seen[url] = true
+			queue = append(queue, page{url, url})
+		}
+	}
 
-		go func(url string) {
-			err := getResponse(url, token, ch)
-			if err != nil {
-				ch <- &Response{url, nil, []byte{}, err}
-			}
-		}(url)
+	workers := e.MaxConcurrentRequests()
+	if workers > len(queue) {
+		workers = len(queue)
+	}
 
+	jobs := make(chan page)
+	results := make(chan *Response)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for p := range jobs {
+				results <- e.getResponse(ctx, p.url, p.target)
+			}
+		}()
 	}
 
-	for {
+	responses := []*Response{}
+	inFlight := 0
+
+	for len(queue) > 0 || inFlight > 0 {
+		// Sending is disabled by a nil channel while the queue is empty
+		var send chan page
+		var next page
+		if len(queue) > 0 {
+			send, next = jobs, queue[0]
+		}
+
 		select {
-		case r := <-ch:
-			if r.err != nil {
-				log.Errorf("Error scraping API, Error: %v", r.err)
-				return nil, r.err
-			}
+		case send <- next:
+			queue = queue[1:]
+			inFlight++
+		case r := <-results:
+			inFlight--
 			responses = append(responses, r)
-
-			if len(responses) == len(targets) {
-				return responses, nil
+			// Only the first page of a target is requested by its own URL
+			if r.err == nil && r.url == r.target {
+				for _, url := range remainingPages(r.url, r.response.Header) {
+					queue = append(queue, page{url, r.target})
+				}
 			}
 		}
-
 	}
-}
+	close(jobs)
 
-// paginateTargets returns all pages for the provided targets
-func paginateTargets(targets []string, token string) []string {
+	return responses
+}
 
-	paginated := targets
+// remainingPages returns the URLs of the pages following the first, as named by the last link in its Link header
+func remainingPages(url string, header http.Header) []string {
+	pages := []string{}
 
-	for _, url := range targets {
+	if header["Link"] == nil {
+		return pages
+	}
 
-		// make a request to the original target to get link header if it exists
-		resp, err := getHTTPResponse(url, token)
-		if err != nil {
-			log.Errorf("Error retrieving Link headers, Error: %s", err)
+	for _, link := range linkheader.Parse(header["Link"][0]) {
+		if link.Rel != "last" {
 			continue
 		}
 
-		if resp.Header["Link"] != nil {
-			links := linkheader.Parse(resp.Header["Link"][0])
-
-			for _, link := range links {
-				if link.Rel == "last" {
-
-					u, err := neturl.Parse(link.URL)
-					if err != nil {
-						log.Errorf("Unable to parse page URL, Error: %s", err)
-					}
-
-					q := u.Query()
+		u, err := neturl.Parse(link.URL)
+		if err != nil {
+			log.Errorf("Unable to parse page URL, Error: %s", err)
+			break
+		}
 
-					lastPage, err := strconv.Atoi(q.Get("page"))
-					if err != nil {
-						log.Errorf("Unable to convert page substring to int, Error: %s", err)
-					}
+		q := u.Query()
 
-					// add all pages to the slice of targets to return
-					for page := 2; page <= lastPage; page++ {
-						q.Set("page", strconv.Itoa(page))
-						u.RawQuery = q.Encode()
-						paginated = append(paginated, u.String())
-					}
+		lastPage, err := strconv.Atoi(q.Get("page"))
+		if err != nil {
+			log.Errorf("Unable to convert page substring to int, Error: %s", err)
+		}
 
-					break
-				}
-			}
+		// add all pages to the set of targets to return
+		for page := 2; page <= lastPage; page++ {
+			q.Set("page", strconv.Itoa(page))
+			u.RawQuery = q.Encode()
+			pages = append(pages, u.String())
 		}
+
+		break
 	}
-	return paginated
+
+	return pages
 }
 
-// getResponse collects an individual http.response and returns a *Response
-func getResponse(url string, token string, ch chan<- *Response) error {
+// getResponse collects an individual http.response and returns a *Response, recording any error on it
+func (e *Exporter) getResponse(ctx context.Context, url string, target string) *Response {
 
 	log.Infof("Fetching %s \n", url)
 
-	resp, err := getHTTPResponse(url, token) // do this earlier
+	resp, err := e.getHTTPResponse(ctx, url)
 	if err != nil {
-		return fmt.Errorf("Error fetching http response: %v", err)
+		return &Response{url, target, nil, []byte{}, fmt.Errorf("Error fetching http response: %v", err)}
 	}
 	defer resp.Body.Close()
 
 	// Read the body to a byte array so it can be used elsewhere
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("Error converting body to byte array: %v", err)
+		return &Response{url, target, nil, []byte{}, fmt.Errorf("Error converting body to byte array: %v", err)}
 	}
 
 	// Triggers if a user specifies an invalid or not visible repository
 	if resp.StatusCode == 404 {
-		return fmt.Errorf("Error: Received 404 status from Github API, ensure the repository URL is correct. If it's a private repository, also check the oauth token is correct")
+		return &Response{url, target, nil, []byte{}, fmt.Errorf("Error: Received 404 status from Github API, ensure the repository URL is correct. If it's a private repository, also check the oauth token is correct")}
 	}
 
-	ch <- &Response{url, resp, body, err}
+	if resp.StatusCode >= 400 {
+		return &Response{url, target, nil, []byte{}, fmt.Errorf("Error: Received %s status from Github API", resp.Status)}
+	}
 
-	return nil
+	return &Response{url, target, resp, body, nil}
 }
 
-// getHTTPResponse handles the http client creation, token setting and returns the *http.response
-func getHTTPResponse(url string, token string) (*http.Response, error) {
+// getHTTPResponse handles the token setting and returns the *http.response
+func (e *Exporter) getHTTPResponse(ctx context.Context, url string) (*http.Response, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 
-	client := &http.Client{
-		Timeout: time.Second * 10,
+	if err != nil {
+		return nil, err
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	return e.doHTTPRequest(req)
+}
+
+// postHTTPResponse sends a JSON body to the url, as used by the GraphQL API, and returns the *http.response
+func (e *Exporter) postHTTPResponse(ctx context.Context, url string, body []byte) (*http.Response, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return e.doHTTPRequest(req)
+}
 
-	// If a token is present, add it to the http.request
-	if token != "" {
-		req.Header.Add("Authorization", "token "+token)
+// doHTTPRequest sends the request with the token with the most requests remaining, unless its
+// context names one, and checks whether the rate limit was exceeded. A request refused by the
+// rate limit is sent again with another token which has requests remaining, if there is one.
+// GET responses are cached for the token they were made with, so that a request for an unchanged
+// resource is answered from the cache following a 304 Not Modified response, which does not
+// count against the rate limit.
+func (e *Exporter) doHTTPRequest(req *http.Request) (*http.Response, error) {
+
+	token, pinned := req.Context().Value(tokenKey{}).(string)
+	if !pinned {
+		token, _ = e.tokens.pick()
 	}
+	tried := map[string]bool{}
 
-	resp, err := client.Do(req)
+	for {
+		// If a token is present, add it to the http.request
+		if token != "" {
+			req.Header.Set("Authorization", "token "+token)
+		}
+		tried[token] = true
+
+		var cached *cachedResponse
+		key := responseCacheKey(token, req.URL.String())
+		if req.Method == http.MethodGet {
+			// Validators cached for another token must not be sent after rotating
+			req.Header.Del("If-None-Match")
+			req.Header.Del("If-Modified-Since")
+			if cached = httpCache.get(key); cached != nil {
+				cached.setConditionalHeaders(req)
+			}
+		}
 
-	if err != nil {
-		return nil, err
+		resp, err := e.client.Do(req)
+
+		if err != nil {
+			return nil, err
+		}
+		e.tokens.observe(token, resp)
+
+		// check rate limit exceeded, once any retries have been exhausted.
+		if resp.Status == RateLimitExceededStatus || isRateLimited(resp) {
+			resp.Body.Close()
+
+			next, headroom := e.tokens.pick()
+			if pinned || tried[next] || headroom <= 0 {
+				return nil, fmt.Errorf("rate limit exceeded: %s", resp.Status)
+			}
+			log.Warnf("Rate limit exceeded for token %s, retrying with token %s", tokenFingerprint(token), tokenFingerprint(next))
+			token = next
+			if req.GetBody != nil {
+				if req.Body, err = req.GetBody(); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if req.Method == http.MethodGet {
+			return httpCache.revalidate(key, req.URL.String(), cached, resp)
+		}
+
+		return resp, err
 	}
+}
 
-	// check rate limit exceeded.
-	if resp.Status == RateLimitExceededStatus {
-		resp.Body.Close()
-		return nil, fmt.Errorf("%s", resp.Status)
+// rotatable reports whether a request refused by the rate limit of its token could be sent by
+// doHTTPRequest with another token from the pool, which is not the case if its token is pinned
+func (e *Exporter) rotatable(req *http.Request) bool {
+	if _, pinned := req.Context().Value(tokenKey{}).(string); pinned {
+		return false
 	}
+	return e.tokens.hasOther(strings.TrimPrefix(req.Header.Get("Authorization"), "token "))
+}
+
+// isRateLimited reports whether the response was refused by a primary or secondary rate limit
+func isRateLimited(resp *http.Response) bool {
+	reason, _ := retryReason(resp, nil)
+	return reason == retryRateLimit || reason == retrySecondaryRateLimit
+}
 
-	return resp, err
+// newHTTPClient returns the client requests to the API are sent with, retrying those which
+// fail transiently. The configured proxy and TLS settings apply to every attempt, and
+// each request counted against the rate limit is added to requests. Requests refused by
+// the rate limit are returned without waiting for the reset when rotatable reports another
+// token could send them.
+func newHTTPClient(c *config.Config, requests *atomic.Int64, rotatable func(*http.Request) bool) *http.Client {
+	t := &retryTransport{timeout: c.HTTPTimeout(), requests: requests, rotatable: rotatable}
+	// Leave the transport unset without any settings, so that http.DefaultTransport is used
+	if transport := c.HTTPTransport(); transport != nil {
+		t.next = transport
+	}
+	return &http.Client{Transport: t}
 }
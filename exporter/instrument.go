@@ -0,0 +1,116 @@
+package exporter
+
+import (
+	neturl "net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The API request and response cache metrics are recorded by the client of every exporter
+// and probe alike, so they are registered once here rather than by each exporter.
+var (
+	apiRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName("github", "exporter", "api_requests_total"),
+			Help: "Total number of requests made to the GitHub API by endpoint and status code",
+		},
+		[]string{"endpoint", "status_code"},
+	)
+	apiRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    prometheus.BuildFQName("github", "exporter", "api_request_duration_seconds"),
+			Help:    "Time taken to receive a response from the GitHub API by endpoint",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"endpoint"},
+	)
+	apiRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName("github", "exporter", "api_retries_total"),
+			Help: "Total number of requests to the GitHub API retried by endpoint and reason",
+		},
+		[]string{"endpoint", "reason"},
+	)
+	cacheHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName("github", "exporter", "http_cache_hits_total"),
+			Help: "Total number of GitHub API requests answered from the response cache after a 304 Not Modified response",
+		},
+		[]string{"endpoint"},
+	)
+	cacheMisses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName("github", "exporter", "http_cache_misses_total"),
+			Help: "Total number of GitHub API requests which could not be answered from the response cache",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(apiRequests, apiRequestDuration, apiRetries, cacheHits, cacheMisses)
+}
+
+// numericSegment matches path segments holding an ID, such as a workflow run
+var numericSegment = regexp.MustCompile(`^[0-9]+$`)
+
+// observeAPIRequest records a request to the GitHub API. A status code of zero
+// records a request which failed without a response.
+func observeAPIRequest(url string, statusCode int, duration time.Duration) {
+	endpoint := apiEndpoint(url)
+
+	status := "error"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+
+	apiRequests.WithLabelValues(endpoint, status).Inc()
+	apiRequestDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+}
+
+// apiEndpoint reduces a request URL to its endpoint, replacing owner, repository,
+// organisation, user and ID segments with placeholders to bound the cardinality,
+// e.g. https://api.github.com/repos/myOrg/myRepo/pulls?page=2 becomes /repos/:owner/:repo/pulls.
+// Any path prefix of a GitHub Enterprise API URL is dropped.
+func apiEndpoint(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "unknown"
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	for i, s := range segments {
+		var placeholders []string
+		switch s {
+		case "repos":
+			placeholders = []string{":owner", ":repo"}
+		case "orgs":
+			placeholders = []string{":org"}
+		case "users":
+			placeholders = []string{":user"}
+		case "rate_limit", "graphql", "search", "installation":
+		default:
+			continue
+		}
+
+		endpoint := segments[i:]
+		for j, p := range placeholders {
+			if j+1 < len(endpoint) {
+				endpoint[j+1] = p
+			}
+		}
+		for j := len(placeholders) + 1; j < len(endpoint); j++ {
+			if numericSegment.MatchString(endpoint[j]) {
+				endpoint[j] = ":id"
+			}
+		}
+		return "/" + strings.Join(endpoint, "/")
+	}
+
+	return "unknown"
+}
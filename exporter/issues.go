@@ -0,0 +1,185 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// issueAgeBuckets are the histogram buckets, in seconds, used for the ages of open issues
+var issueAgeBuckets = []float64{3600, 4 * 3600, 24 * 3600, 3 * 24 * 3600, 7 * 24 * 3600, 14 * 24 * 3600, 30 * 24 * 3600, 90 * 24 * 3600, 180 * 24 * 3600, 365 * 24 * 3600}
+
+// issuesCollector exposes the open and closed issues of repositories configured in REPOS, in total
+// and with each configured label. Pull requests, which the API counts as issues, are excluded.
+type issuesCollector struct {
+	issues     *prometheus.Desc
+	unassigned *prometheus.Desc
+	age        *prometheus.Desc
+}
+
+func newIssuesCollector() *issuesCollector {
+	return &issuesCollector{
+		issues: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "issues"),
+			"Number of issues by state with the given label, or with any label when empty",
+			[]string{"repo", "user", "state", "label"}, nil,
+		),
+		unassigned: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "issues_unassigned"),
+			"Number of open issues without an assignee with the given label, or with any label when empty",
+			[]string{"repo", "user", "label"}, nil,
+		),
+		age: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "issue_age_seconds"),
+			"Time since open issues with the given label, or with any label when empty, were created",
+			[]string{"repo", "user", "label"}, nil,
+		),
+	}
+}
+
+func (c *issuesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.issues
+	ch <- c.unassigned
+	ch <- c.age
+}
+
+// Update fetches the open issues of each repository from the REST API, and counts their closed
+// issues rather than listing every closed issue, through the GraphQL API in batches when it is
+// the authenticated backend, or else through the search API
+func (c *issuesCollector) Update(ctx context.Context, e *Exporter, s *Snapshot) error {
+	s.IssueLabels = e.IssueLabels()
+
+	repos := []*Datum{}
+	for _, d := range s.Data {
+		if !d.isRepoTarget() {
+			continue
+		}
+		issues := []Issue{}
+		if err := getIssues(ctx, e, d, &issues); err != nil {
+			log.Errorf("Unable to obtain issues for target %s, Error: %s", d.Target, err)
+			s.TargetUp[d.Target] = false
+			continue
+		}
+		d.Issues = issues
+		repos = append(repos, d)
+	}
+
+	if !countsByGraphQL(&e.Config) {
+		for _, d := range repos {
+			if err := getClosedIssueCounts(ctx, e, d, s.IssueLabels); err != nil {
+				log.Errorf("Unable to count closed issues for target %s, Error: %s", d.Target, err)
+				s.TargetUp[d.Target] = false
+			}
+		}
+		return nil
+	}
+	for start := 0; start < len(repos); start += graphQLBatchSize {
+		end := start + graphQLBatchSize
+		if end > len(repos) {
+			end = len(repos)
+		}
+		for _, target := range e.graphQLClosedIssueCounts(ctx, repos[start:end], s.IssueLabels) {
+			s.TargetUp[target] = false
+		}
+	}
+	return nil
+}
+
+// carry keeps the issues of the last refresh, along with the labels they were counted by
+func (c *issuesCollector) carry(e *Exporter, prev Snapshot, s *Snapshot) {
+	s.IssueLabels = prev.IssueLabels
+	carryData(prev, s, func(p, d *Datum) {
+		d.Issues = p.Issues
+		d.ClosedIssues = p.ClosedIssues
+	})
+}
+
+// Collect counts the open issues of each repository, those without an assignee, and observes their
+// ages as of the refresh, in total and for each label, along with the closed issues if they were counted
+func (c *issuesCollector) Collect(s Snapshot, ch chan<- prometheus.Metric) {
+	for _, x := range s.Data {
+		if x.Issues == nil {
+			continue
+		}
+
+		for _, label := range append([]string{""}, s.IssueLabels...) {
+			open, unassigned := 0.0, 0.0
+			ages := []float64{}
+
+			for _, issue := range x.Issues {
+				if label != "" && !issue.hasLabel(label) {
+					continue
+				}
+				open++
+				if len(issue.Assignees) == 0 {
+					unassigned++
+				}
+				ages = append(ages, s.RefreshedAt.Sub(issue.CreatedAt).Seconds())
+			}
+
+			ch <- prometheus.MustNewConstMetric(c.issues, prometheus.GaugeValue, open, x.Name, x.Owner.Login, "open", label)
+			if x.ClosedIssues != nil {
+				ch <- prometheus.MustNewConstMetric(c.issues, prometheus.GaugeValue, x.ClosedIssues[label], x.Name, x.Owner.Login, "closed", label)
+			}
+			ch <- prometheus.MustNewConstMetric(c.unassigned, prometheus.GaugeValue, unassigned, x.Name, x.Owner.Login, label)
+			ch <- newConstHistogram(c.age, issueAgeBuckets, ages, x.Name, x.Owner.Login, label)
+		}
+	}
+}
+
+// hasLabel reports whether the issue has the label, which like GitHub ignores case
+func (i Issue) hasLabel(label string) bool {
+	for _, l := range i.Labels {
+		if strings.EqualFold(l.Name, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// getIssues fetches the open issues of a repository, dropping the pull requests the endpoint also lists
+func getIssues(ctx context.Context, e *Exporter, d *Datum, data *[]Issue) error {
+	issuesURL := repoURL(e, d, "issues") + "?state=open&per_page=100"
+	issuesResponse := e.asyncHTTPGets(ctx, []string{issuesURL})
+
+	for _, r := range issuesResponse {
+		if r.err != nil {
+			return r.err
+		}
+		page := []Issue{}
+		if err := json.Unmarshal(r.body, &page); err != nil {
+			return err
+		}
+		for _, issue := range page {
+			if issue.PullRequest == nil {
+				*data = append(*data, issue)
+			}
+		}
+	}
+
+	return nil
+}
+
+// getClosedIssueCounts counts the closed issues of a repository through the search API, in total and with
+// each of the labels, keyed by label with the total under the empty label
+func getClosedIssueCounts(ctx context.Context, e *Exporter, d *Datum, labels []string) error {
+	counts := map[string]float64{}
+	for _, label := range append([]string{""}, labels...) {
+		query := fmt.Sprintf("repo:%s/%s is:issue is:closed", d.Owner.Login, d.Name)
+		if label != "" {
+			query += fmt.Sprintf(" label:%q", label)
+		}
+		count, err := searchIssuesCount(ctx, e, query)
+		if err != nil {
+			return err
+		}
+		counts[label] = count
+	}
+
+	d.ClosedIssues = counts
+	return nil
+}
@@ -1,100 +1,108 @@
 package exporter
 
 import (
-	"strconv"
-
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// AddMetrics - Add's all of the metrics to a map of strings, returns the map.
+// AddMetrics - Add's the metrics of the exporter itself to a map of strings, returns the map.
+// Metrics gathered from the API are described by each Collector.
 func AddMetrics() map[string]*prometheus.Desc {
 
 	APIMetrics := make(map[string]*prometheus.Desc)
 
-	APIMetrics["Stars"] = prometheus.NewDesc(
-		prometheus.BuildFQName("github", "repo", "stars"),
-		"Total number of Stars for given repository",
-		[]string{"repo", "user", "private", "fork", "archived", "license", "language"}, nil,
-	)
-	APIMetrics["OpenIssues"] = prometheus.NewDesc(
-		prometheus.BuildFQName("github", "repo", "open_issues"),
-		"Total number of open issues for given repository",
-		[]string{"repo", "user", "private", "fork", "archived", "license", "language"}, nil,
-	)
-	APIMetrics["PullRequestCount"] = prometheus.NewDesc(
-		prometheus.BuildFQName("github", "repo", "pull_request_count"),
-		"Total number of pull requests for given repository",
-		[]string{"repo", "user"}, nil,
+	APIMetrics["TargetUp"] = prometheus.NewDesc(
+		prometheus.BuildFQName("github", "target", "up"),
+		"Whether the last refresh of the given repository, organisation or user target succeeded",
+		[]string{"target"}, nil,
 	)
-	APIMetrics["Watchers"] = prometheus.NewDesc(
-		prometheus.BuildFQName("github", "repo", "watchers"),
-		"Total number of watchers/subscribers for given repository",
-		[]string{"repo", "user", "private", "fork", "archived", "license", "language"}, nil,
+	APIMetrics["TargetScrapeErrors"] = prometheus.NewDesc(
+		prometheus.BuildFQName("github", "target", "scrape_errors_total"),
+		"Total number of refreshes in which the given target failed to be scraped",
+		[]string{"target"}, nil,
 	)
-	APIMetrics["Forks"] = prometheus.NewDesc(
-		prometheus.BuildFQName("github", "repo", "forks"),
-		"Total number of forks for given repository",
-		[]string{"repo", "user", "private", "fork", "archived", "license", "language"}, nil,
+	APIMetrics["LastRefresh"] = prometheus.NewDesc(
+		prometheus.BuildFQName("github", "exporter", "last_refresh_timestamp_seconds"),
+		"The time at which data was last successfully refreshed from the API for every target in UTC epoch seconds, absent until then",
+		[]string{}, nil,
 	)
-	APIMetrics["Size"] = prometheus.NewDesc(
-		prometheus.BuildFQName("github", "repo", "size_kb"),
-		"Size in KB for given repository",
-		[]string{"repo", "user", "private", "fork", "archived", "license", "language"}, nil,
+	APIMetrics["ScrapeDuration"] = prometheus.NewDesc(
+		prometheus.BuildFQName("github", "exporter", "scrape_duration_seconds"),
+		"Time taken by the last refresh of data from the API",
+		[]string{}, nil,
 	)
-	APIMetrics["ReleaseDownloads"] = prometheus.NewDesc(
-		prometheus.BuildFQName("github", "repo", "release_downloads"),
-		"Download count for a given release",
-		[]string{"repo", "user", "release", "name", "tag", "created_at"}, nil,
+	APIMetrics["ScrapeSuccess"] = prometheus.NewDesc(
+		prometheus.BuildFQName("github", "exporter", "scrape_success"),
+		"Whether the last refresh of data from the API succeeded for every target",
+		[]string{}, nil,
 	)
-	APIMetrics["Limit"] = prometheus.NewDesc(
-		prometheus.BuildFQName("github", "rate", "limit"),
-		"Number of API queries allowed in a 60 minute window",
+	APIMetrics["TokenExpiry"] = prometheus.NewDesc(
+		prometheus.BuildFQName("github", "exporter", "token_expiry_timestamp_seconds"),
+		"Time at which the GitHub App installation token expires in UTC epoch seconds, absent unless authenticated as a GitHub App",
 		[]string{}, nil,
 	)
-	APIMetrics["Remaining"] = prometheus.NewDesc(
-		prometheus.BuildFQName("github", "rate", "remaining"),
-		"Number of API queries remaining in the current window",
+	APIMetrics["RefreshInterval"] = prometheus.NewDesc(
+		prometheus.BuildFQName("github", "exporter", "refresh_interval_seconds"),
+		"Time until the next refresh, stretched from the configured interval when needed to stay within the rate limit",
 		[]string{}, nil,
 	)
-	APIMetrics["Reset"] = prometheus.NewDesc(
-		prometheus.BuildFQName("github", "rate", "reset"),
-		"The time at which the current rate limit window resets in UTC epoch seconds",
+	APIMetrics["RefreshRequests"] = prometheus.NewDesc(
+		prometheus.BuildFQName("github", "exporter", "refresh_requests"),
+		"Number of requests counted against the rate limit by the last refresh",
 		[]string{}, nil,
 	)
+	APIMetrics["CollectorSkipped"] = prometheus.NewDesc(
+		prometheus.BuildFQName("github", "exporter", "collector_skipped"),
+		"Whether the given enabled collector was skipped by the last refresh to stay within the rate limit",
+		[]string{"collector"}, nil,
+	)
 
 	return APIMetrics
 }
 
-// processMetrics - processes the response data and sets the metrics using it as a source
-func (e *Exporter) processMetrics(data []*Datum, rates *RateLimits, ch chan<- prometheus.Metric) error {
+// processTargetMetrics - sets the per target health metrics
+func (e *Exporter) processTargetMetrics(up map[string]bool, errs map[string]float64, ch chan<- prometheus.Metric) {
+	for target, ok := range up {
+		v := 0.0
+		if ok {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(e.APIMetrics["TargetUp"], prometheus.GaugeValue, v, target)
+	}
 
-	// APIMetrics - range through the data slice
-	for _, x := range data {
-		ch <- prometheus.MustNewConstMetric(e.APIMetrics["Stars"], prometheus.GaugeValue, x.Stars, x.Name, x.Owner.Login, strconv.FormatBool(x.Private), strconv.FormatBool(x.Fork), strconv.FormatBool(x.Archived), x.License.Key, x.Language)
-		ch <- prometheus.MustNewConstMetric(e.APIMetrics["Forks"], prometheus.GaugeValue, x.Forks, x.Name, x.Owner.Login, strconv.FormatBool(x.Private), strconv.FormatBool(x.Fork), strconv.FormatBool(x.Archived), x.License.Key, x.Language)
-		ch <- prometheus.MustNewConstMetric(e.APIMetrics["Watchers"], prometheus.GaugeValue, x.Watchers, x.Name, x.Owner.Login, strconv.FormatBool(x.Private), strconv.FormatBool(x.Fork), strconv.FormatBool(x.Archived), x.License.Key, x.Language)
-		ch <- prometheus.MustNewConstMetric(e.APIMetrics["Size"], prometheus.GaugeValue, x.Size, x.Name, x.Owner.Login, strconv.FormatBool(x.Private), strconv.FormatBool(x.Fork), strconv.FormatBool(x.Archived), x.License.Key, x.Language)
+	for target, count := range errs {
+		ch <- prometheus.MustNewConstMetric(e.APIMetrics["TargetScrapeErrors"], prometheus.CounterValue, count, target)
+	}
+}
+
+// newConstHistogram - builds a histogram metric from a set of observations
+func newConstHistogram(desc *prometheus.Desc, buckets []float64, observations []float64, labelValues ...string) prometheus.Metric {
+	counts := make(map[float64]uint64, len(buckets))
+	for _, b := range buckets {
+		counts[b] = 0
+	}
+	sum := 0.0
 
-		for _, release := range x.Releases {
-			for _, asset := range release.Assets {
-				ch <- prometheus.MustNewConstMetric(e.APIMetrics["ReleaseDownloads"], prometheus.GaugeValue, float64(asset.Downloads), x.Name, x.Owner.Login, release.Name, asset.Name, release.Tag, asset.CreatedAt)
+	for _, o := range observations {
+		sum += o
+		for _, b := range buckets {
+			if o <= b {
+				counts[b]++
 			}
 		}
-		prCount := 0
-		for range x.Pulls {
-			prCount += 1
-		}
-		// issueCount = x.OpenIssue - prCount
-		ch <- prometheus.MustNewConstMetric(e.APIMetrics["OpenIssues"], prometheus.GaugeValue, (x.OpenIssues - float64(prCount)), x.Name, x.Owner.Login, strconv.FormatBool(x.Private), strconv.FormatBool(x.Fork), strconv.FormatBool(x.Archived), x.License.Key, x.Language)
-
-		// prCount
-		ch <- prometheus.MustNewConstMetric(e.APIMetrics["PullRequestCount"], prometheus.GaugeValue, float64(prCount), x.Name, x.Owner.Login)
 	}
 
-	// Set Rate limit stats
-	ch <- prometheus.MustNewConstMetric(e.APIMetrics["Limit"], prometheus.GaugeValue, rates.Limit)
-	ch <- prometheus.MustNewConstMetric(e.APIMetrics["Remaining"], prometheus.GaugeValue, rates.Remaining)
-	ch <- prometheus.MustNewConstMetric(e.APIMetrics["Reset"], prometheus.GaugeValue, rates.Reset)
+	return prometheus.MustNewConstHistogram(desc, uint64(len(observations)), sum, counts, labelValues...)
+}
+
+// processBudgetMetrics - sets the metrics describing how the last refresh kept within the rate limit
+func (e *Exporter) processBudgetMetrics(s Snapshot, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(e.APIMetrics["RefreshInterval"], prometheus.GaugeValue, s.Interval.Seconds())
+	ch <- prometheus.MustNewConstMetric(e.APIMetrics["RefreshRequests"], prometheus.GaugeValue, s.Requests)
 
-	return nil
+	for _, name := range s.Collectors {
+		ch <- prometheus.MustNewConstMetric(e.APIMetrics["CollectorSkipped"], prometheus.GaugeValue, 0, name)
+	}
+	for _, name := range s.Skipped {
+		ch <- prometheus.MustNewConstMetric(e.APIMetrics["CollectorSkipped"], prometheus.GaugeValue, 1, name)
+	}
 }
@@ -0,0 +1,34 @@
+package exporter
+
+import (
+	"context"
+)
+
+// Probe returns an exporter scraping only the given target, in the form "repo:owner/name",
+// "org:name" or "user:name", using the API, credentials and collectors of this exporter.
+// Probes are not refreshed in the background, so its data is gathered before it is returned,
+// unless the context is done by then. A refresh of this exporter in progress is not waited for,
+// while a configuration reloaded since it started is used.
+func (e *Exporter) Probe(ctx context.Context, target string) (*Exporter, error) {
+	e.configMu.Lock()
+	c := e.Config
+	if e.pending != nil {
+		c = *e.pending
+	}
+	e.configMu.Unlock()
+
+	if err := c.SetTarget(target); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p := &Exporter{
+		APIMetrics: e.APIMetrics,
+		Config:     c,
+	}
+	p.refresh(ctx)
+
+	return p, nil
+}
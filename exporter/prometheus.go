@@ -1,93 +1,64 @@
 package exporter
 
 import (
-	"path"
-	"strconv"
-
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
-// Describe - loops through the API metrics and passes them to prometheus.Describe
+// Describe - loops through the API metrics and every collector's metrics and passes them to prometheus.Describe.
+// Collectors are described whether or not they are enabled, as that may change on reload.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 
 	for _, m := range e.APIMetrics {
 		ch <- m
 	}
 
+	for _, c := range collectors {
+		c.collector.Describe(ch)
+	}
+
 }
 
 // Collect function, called on by Prometheus Client library
-// This function is called when a scrape is peformed on the /metrics page
+// This function is called when a scrape is peformed on the /metrics page.
+// Metrics are only served from the snapshot kept by the background refresher,
+// so until its first refresh lands a scrape reports only that it was unsuccessful.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	data := []*Datum{}
-	var err error
-
-	if e.Config.GitHubApp() {
-		needReAuth, err := e.isTokenExpired()
-		if err != nil {
-			log.Errorf("Error checking token expiration status: %v", err)
-			return
-		}
-		if needReAuth {
-			err = e.Config.SetAPITokenFromGitHubApp()
-			if err != nil {
-				log.Errorf("Error authenticating with GitHub app: %v", err)
-			}
-		}
-	}
-	// Scrape the Data from Github
-	if len(e.TargetURLs()) > 0 {
-		data, err = e.gatherData()
-		if err != nil {
-			log.Errorf("Error gathering Data from remote API: %v", err)
-			return
-		}
-	}
-
-	rates, err := e.getRates()
-	if err != nil {
-		log.Errorf("Error gathering Rates from remote API: %v", err)
+	s := e.Snapshot()
+	if s.RefreshedAt.IsZero() {
+		log.Warn("No data has been gathered from the GitHub API yet")
+		ch <- prometheus.MustNewConstMetric(e.APIMetrics["ScrapeSuccess"], prometheus.GaugeValue, 0)
 		return
 	}
 
-	// Set prometheus gauge metrics using the data gathered
-	err = e.processMetrics(data, rates, ch)
-
-	if err != nil {
-		log.Error("Error Processing Metrics", err)
-		return
+	// Set prometheus metrics from each collector enabled when the data was gathered,
+	// including those skipped, which serve the data carried forward for them
+	for _, name := range append(append([]string{}, s.Collectors...), s.Skipped...) {
+		lookupCollector(name).Collect(s, ch)
 	}
 
-	log.Info("All Metrics successfully collected")
-
-}
-
-func (e *Exporter) isTokenExpired() (bool, error) {
-	u := *e.APIURL()
-	u.Path = path.Join(u.Path, "rate_limit")
-
-	resp, err := getHTTPResponse(u.String(), e.APIToken())
+	e.processTargetMetrics(s.TargetUp, s.TargetErrors, ch)
+	e.processBudgetMetrics(s, ch)
 
-	if err != nil {
-		return false, err
+	if !s.SucceededAt.IsZero() {
+		ch <- prometheus.MustNewConstMetric(e.APIMetrics["LastRefresh"], prometheus.GaugeValue, float64(s.SucceededAt.Unix()))
 	}
-	defer resp.Body.Close()
-	// Triggers if rate-limiting isn't enabled on private Github Enterprise installations
-	if resp.StatusCode == 404 {
-		return false, nil
+	ch <- prometheus.MustNewConstMetric(e.APIMetrics["ScrapeDuration"], prometheus.GaugeValue, s.Duration.Seconds())
+	ch <- prometheus.MustNewConstMetric(e.APIMetrics["ScrapeSuccess"], prometheus.GaugeValue, scrapeSuccess(s.TargetUp))
+	if !s.TokenExpiry.IsZero() {
+		ch <- prometheus.MustNewConstMetric(e.APIMetrics["TokenExpiry"], prometheus.GaugeValue, float64(s.TokenExpiry.Unix()))
 	}
 
-	limit, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Limit"), 64)
+	log.Info("All Metrics successfully collected")
 
-	if err != nil {
-		return false, err
-	}
+}
 
-	defaultRateLimit := e.Config.GitHubRateLimit()
-	if limit < defaultRateLimit {
-		return true, nil
+// scrapeSuccess returns 1 if every target was scraped without error
+func scrapeSuccess(up map[string]bool) float64 {
+	for _, ok := range up {
+		if !ok {
+			return 0
+		}
 	}
-	return false, nil
-
+	return 1
 }
@@ -0,0 +1,343 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// leadTimeBuckets are the histogram buckets, in seconds, used for pull request ages and lead times
+var leadTimeBuckets = []float64{3600, 4 * 3600, 12 * 3600, 24 * 3600, 3 * 24 * 3600, 7 * 24 * 3600, 14 * 24 * 3600, 30 * 24 * 3600}
+
+// reviewBuckets are the histogram buckets, in seconds, used for the time until pull requests are reviewed
+var reviewBuckets = []float64{300, 900, 1800, 3600, 4 * 3600, 12 * 3600, 24 * 3600, 3 * 24 * 3600, 7 * 24 * 3600}
+
+// pullCollector exposes the number of open pull requests of each repository
+type pullCollector struct {
+	count *prometheus.Desc
+}
+
+func newPullCollector() *pullCollector {
+	return &pullCollector{
+		count: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "pull_request_count"),
+			"Total number of pull requests for given repository",
+			[]string{"repo", "user"}, nil,
+		),
+	}
+}
+
+func (c *pullCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.count
+}
+
+// Update counts the open pull requests of each repository, unless they were counted by the GraphQL
+// backend. When the GraphQL API is configured and authenticated every repository is counted in
+// batches through it, otherwise those of the repositories in REPOS are counted through the search
+// API, leaving the repositories of organisations and users uncounted.
+func (c *pullCollector) Update(ctx context.Context, e *Exporter, s *Snapshot) error {
+	if usesGraphQL(&e.Config) {
+		return nil
+	}
+	if !countsByGraphQL(&e.Config) {
+		for _, d := range s.Data {
+			if !d.isRepoTarget() {
+				continue
+			}
+			count, err := searchIssuesCount(ctx, e, fmt.Sprintf("repo:%s/%s is:pr is:open", d.Owner.Login, d.Name))
+			if err != nil {
+				log.Errorf("Unable to count pull requests for target %s, Error: %s", d.Target, err)
+				s.TargetUp[d.Target] = false
+				continue
+			}
+			d.OpenPulls, d.PullsCounted = count, true
+		}
+		return nil
+	}
+	for start := 0; start < len(s.Data); start += graphQLBatchSize {
+		end := start + graphQLBatchSize
+		if end > len(s.Data) {
+			end = len(s.Data)
+		}
+		for _, target := range e.graphQLPullCounts(ctx, s.Data[start:end]) {
+			s.TargetUp[target] = false
+		}
+	}
+	return nil
+}
+
+// carry keeps the open pull request counts of the last refresh, unless they were counted by the GraphQL
+// backend, so that they are still excluded from the open issue count of each repository
+func (c *pullCollector) carry(e *Exporter, prev Snapshot, s *Snapshot) {
+	if usesGraphQL(&e.Config) {
+		return
+	}
+	carryData(prev, s, func(p, d *Datum) {
+		d.OpenPulls = p.OpenPulls
+		d.PullsCounted = p.PullsCounted
+	})
+}
+
+// Collect sends the open pull requests of each repository which were counted
+func (c *pullCollector) Collect(s Snapshot, ch chan<- prometheus.Metric) {
+	for _, x := range s.Data {
+		if x.PullsCounted {
+			ch <- prometheus.MustNewConstMetric(c.count, prometheus.GaugeValue, x.OpenPulls, x.Name, x.Owner.Login)
+		}
+	}
+}
+
+// pullLifecycleCollector exposes the age of open pull requests and the time taken to merge
+// those closed within the lookback window, for repositories configured in REPOS
+type pullLifecycleCollector struct {
+	open        *prometheus.Desc
+	age         *prometheus.Desc
+	timeToMerge *prometheus.Desc
+}
+
+func newPullLifecycleCollector() *pullLifecycleCollector {
+	return &pullLifecycleCollector{
+		open: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "pull_request", "open"),
+			"Number of open pull requests by base branch and whether they are drafts",
+			[]string{"repo", "user", "base", "draft"}, nil,
+		),
+		age: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "pull_request", "age_seconds"),
+			"Time since open pull requests were created by base branch",
+			[]string{"repo", "user", "base"}, nil,
+		),
+		timeToMerge: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "pull_request", "time_to_merge_seconds"),
+			"Time from creation until merge of pull requests merged within the lookback window by base branch",
+			[]string{"repo", "user", "base"}, nil,
+		),
+	}
+}
+
+func (c *pullLifecycleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.open
+	ch <- c.age
+	ch <- c.timeToMerge
+}
+
+// Update fetches the open pull requests of each repository, as the pull collector only counts them,
+// and those closed within the lookback window
+func (c *pullLifecycleCollector) Update(ctx context.Context, e *Exporter, s *Snapshot) error {
+	for _, d := range s.Data {
+		if !d.isRepoTarget() {
+			continue
+		}
+		if err := getPRs(ctx, e, d, &d.Pulls); err != nil {
+			log.Errorf("Unable to obtain pull requests for target %s, Error: %s", d.Target, err)
+			s.TargetUp[d.Target] = false
+			continue
+		}
+		if err := getClosedPRs(ctx, e, d, &d.ClosedPulls); err != nil {
+			log.Errorf("Unable to obtain closed pull requests for target %s, Error: %s", d.Target, err)
+			s.TargetUp[d.Target] = false
+		}
+	}
+	return nil
+}
+
+// carry keeps the open and closed pull requests of the last refresh
+func (c *pullLifecycleCollector) carry(e *Exporter, prev Snapshot, s *Snapshot) {
+	carryData(prev, s, func(p, d *Datum) {
+		d.Pulls = p.Pulls
+		d.ClosedPulls = p.ClosedPulls
+	})
+}
+
+// Collect counts the open pull requests of each repository and observes their ages as of the
+// refresh, along with the time taken to merge the pull requests merged within the lookback window
+func (c *pullLifecycleCollector) Collect(s Snapshot, ch chan<- prometheus.Metric) {
+	type openKey struct {
+		base  string
+		draft bool
+	}
+
+	for _, x := range s.Data {
+		open := map[openKey]float64{}
+		ages := map[string][]float64{}
+		merges := map[string][]float64{}
+
+		for _, p := range x.Pulls {
+			open[openKey{p.Base.Ref, p.Draft}]++
+			ages[p.Base.Ref] = append(ages[p.Base.Ref], s.RefreshedAt.Sub(p.CreatedAt).Seconds())
+		}
+		for _, p := range x.ClosedPulls {
+			if !p.MergedAt.IsZero() {
+				merges[p.Base.Ref] = append(merges[p.Base.Ref], p.MergedAt.Sub(p.CreatedAt).Seconds())
+			}
+		}
+
+		for k, count := range open {
+			ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, count, x.Name, x.Owner.Login, k.base, strconv.FormatBool(k.draft))
+		}
+		for base, observations := range ages {
+			ch <- newConstHistogram(c.age, leadTimeBuckets, observations, x.Name, x.Owner.Login, base)
+		}
+		for base, observations := range merges {
+			ch <- newConstHistogram(c.timeToMerge, leadTimeBuckets, observations, x.Name, x.Owner.Login, base)
+		}
+	}
+}
+
+// pullReviewsCollector exposes the time taken to review the pull requests closed within the
+// lookback window fetched by the pull_lifecycle collector
+type pullReviewsCollector struct {
+	timeToFirstReview *prometheus.Desc
+}
+
+func newPullReviewsCollector() *pullReviewsCollector {
+	return &pullReviewsCollector{
+		timeToFirstReview: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "pull_request", "time_to_first_review_seconds"),
+			"Time from creation until the first review of pull requests closed within the lookback window by base branch",
+			[]string{"repo", "user", "base"}, nil,
+		),
+	}
+}
+
+func (c *pullReviewsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.timeToFirstReview
+}
+
+func (c *pullReviewsCollector) Update(ctx context.Context, e *Exporter, s *Snapshot) error {
+	for _, d := range s.Data {
+		if len(d.ClosedPulls) == 0 {
+			continue
+		}
+		if err := getPullReviews(ctx, e, d, d.ClosedPulls); err != nil {
+			log.Errorf("Unable to obtain pull request reviews for target %s, Error: %s", d.Target, err)
+			s.TargetUp[d.Target] = false
+		}
+	}
+	return nil
+}
+
+// carry keeps the reviews of the last refresh for the closed pull requests which were already fetched
+// then. Pull requests carried forward by a skipped pull_lifecycle collector keep their reviews already.
+func (c *pullReviewsCollector) carry(e *Exporter, prev Snapshot, s *Snapshot) {
+	if !slices.Contains(s.Collectors, "pull_lifecycle") {
+		return
+	}
+	carryData(prev, s, func(p, d *Datum) {
+		reviews := map[int][]Review{}
+		for _, pull := range p.ClosedPulls {
+			reviews[pull.Number] = pull.Reviews
+		}
+		for n := range d.ClosedPulls {
+			d.ClosedPulls[n].Reviews = reviews[d.ClosedPulls[n].Number]
+		}
+	})
+}
+
+// Collect observes the time until the first review of each closed pull request which was reviewed
+// by someone other than its author. Pending reviews have not been submitted, so are ignored.
+func (c *pullReviewsCollector) Collect(s Snapshot, ch chan<- prometheus.Metric) {
+	for _, x := range s.Data {
+		waits := map[string][]float64{}
+
+		for _, p := range x.ClosedPulls {
+			first := time.Time{}
+			for _, r := range p.Reviews {
+				if r.State == "PENDING" || r.User.Login == p.User.Login || r.SubmittedAt.IsZero() {
+					continue
+				}
+				if first.IsZero() || r.SubmittedAt.Before(first) {
+					first = r.SubmittedAt
+				}
+			}
+			if !first.IsZero() {
+				waits[p.Base.Ref] = append(waits[p.Base.Ref], first.Sub(p.CreatedAt).Seconds())
+			}
+		}
+
+		for base, observations := range waits {
+			ch <- newConstHistogram(c.timeToFirstReview, reviewBuckets, observations, x.Name, x.Owner.Login, base)
+		}
+	}
+}
+
+// getPRs fetches every page of the open pull requests of a repository
+func getPRs(ctx context.Context, e *Exporter, d *Datum, data *[]Pull) error {
+	pullsURL := repoURL(e, d, "pulls") + "?per_page=100"
+	pullsResponse := e.asyncHTTPGets(ctx, []string{pullsURL})
+
+	for _, r := range pullsResponse {
+		if r.err != nil {
+			return r.err
+		}
+		page := []Pull{}
+		if err := json.Unmarshal(r.body, &page); err != nil {
+			return err
+		}
+		*data = append(*data, page...)
+	}
+
+	return nil
+}
+
+// getClosedPRs fetches the pull requests closed within the configured lookback window. Closed pull
+// requests are listed most recently updated first, so pages are fetched in turn until one ends
+// with a pull request last updated before the window, rather than paginating the whole history.
+func getClosedPRs(ctx context.Context, e *Exporter, d *Datum, data *[]Pull) error {
+	since := time.Now().Add(-e.PullsLookback())
+
+	for page := 1; ; page++ {
+		pullsURL := repoURL(e, d, "pulls") + fmt.Sprintf("?state=closed&sort=updated&direction=desc&per_page=100&page=%d", page)
+		r := e.getResponse(ctx, pullsURL, pullsURL)
+		if r.err != nil {
+			return r.err
+		}
+		pulls := []Pull{}
+		if err := json.Unmarshal(r.body, &pulls); err != nil {
+			return err
+		}
+
+		for _, p := range pulls {
+			if !p.ClosedAt.Before(since) {
+				*data = append(*data, p)
+			}
+		}
+
+		if len(pulls) < 100 || pulls[len(pulls)-1].UpdatedAt.Before(since) {
+			return nil
+		}
+	}
+}
+
+// getPullReviews fetches the reviews of the supplied pull requests
+func getPullReviews(ctx context.Context, e *Exporter, d *Datum, pulls []Pull) error {
+	reviewsURLs := []string{}
+	byURL := map[string]*Pull{}
+	for n := range pulls {
+		reviewsURL := repoURL(e, d, "pulls", fmt.Sprint(pulls[n].Number), "reviews") + "?per_page=100"
+		reviewsURLs = append(reviewsURLs, reviewsURL)
+		byURL[reviewsURL] = &pulls[n]
+	}
+
+	reviewsResponse := e.asyncHTTPGets(ctx, reviewsURLs)
+
+	for _, r := range reviewsResponse {
+		if r.err != nil {
+			return r.err
+		}
+		page := []Review{}
+		if err := json.Unmarshal(r.body, &page); err != nil {
+			return err
+		}
+		pull := byURL[r.target]
+		pull.Reviews = append(pull.Reviews, page...)
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// rateCollector exposes the API rate limit of each configured token, identified by its fingerprint
+type rateCollector struct {
+	limit     *prometheus.Desc
+	remaining *prometheus.Desc
+	reset     *prometheus.Desc
+	used      *prometheus.Desc
+}
+
+func newRateCollector() *rateCollector {
+	return &rateCollector{
+		limit: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "rate", "limit"),
+			"Number of API queries allowed in a 60 minute window",
+			[]string{"resource", "token"}, nil,
+		),
+		remaining: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "rate", "remaining"),
+			"Number of API queries remaining in the current window",
+			[]string{"resource", "token"}, nil,
+		),
+		reset: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "rate", "reset"),
+			"The time at which the current rate limit window resets in UTC epoch seconds",
+			[]string{"resource", "token"}, nil,
+		),
+		used: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "rate", "used"),
+			"Number of API queries made in the current window",
+			[]string{"resource", "token"}, nil,
+		),
+	}
+}
+
+func (c *rateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.limit
+	ch <- c.remaining
+	ch <- c.reset
+	ch <- c.used
+}
+
+// Update reads the current rate limit of each token, keeping the previous values of a token if
+// they cannot be read. The core rate limits of every token are totalled for the refresh scheduler.
+func (c *rateCollector) Update(ctx context.Context, e *Exporter, s *Snapshot) error {
+	tokens := e.tokens.list()
+	if len(tokens) == 0 {
+		// Requests are made anonymously without a token
+		tokens = []string{""}
+	}
+
+	errs := []error{}
+	s.TokenRates = map[string]*RateLimits{}
+	for _, token := range tokens {
+		fingerprint := tokenFingerprint(token)
+		rates, err := e.getRates(withToken(ctx, token))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("token %s: %v", fingerprint, err))
+			if rates = e.Snapshot().TokenRates[fingerprint]; rates == nil {
+				continue
+			}
+		}
+		s.TokenRates[fingerprint] = rates
+	}
+	s.Rates = totalRates(s.TokenRates)
+
+	return errors.Join(errs...)
+}
+
+// Collect sends the rate limit of each resource for each token, which is absent if it has never been read successfully
+func (c *rateCollector) Collect(s Snapshot, ch chan<- prometheus.Metric) {
+	for token, rates := range s.TokenRates {
+		for resource, rate := range rates.Resources {
+			ch <- prometheus.MustNewConstMetric(c.limit, prometheus.GaugeValue, rate.Limit, resource, token)
+			ch <- prometheus.MustNewConstMetric(c.remaining, prometheus.GaugeValue, rate.Remaining, resource, token)
+			ch <- prometheus.MustNewConstMetric(c.reset, prometheus.GaugeValue, rate.Reset, resource, token)
+			ch <- prometheus.MustNewConstMetric(c.used, prometheus.GaugeValue, rate.Used, resource, token)
+		}
+	}
+}
+
+// totalRates sums the core rate limits of every token, resetting once the last of them has,
+// or returns nil if none are known
+func totalRates(rates map[string]*RateLimits) *RateLimits {
+	if len(rates) == 0 {
+		return nil
+	}
+	total := &RateLimits{}
+	for _, r := range rates {
+		total.Limit += r.Limit
+		total.Remaining += r.Remaining
+		total.Reset = math.Max(total.Reset, r.Reset)
+	}
+	return total
+}
+
+// getRates obtains the rate limit data for requests against the github API.
+// Especially useful when operating without oauth and the subsequent lower cap.
+// The core rate limit is read from the headers, and the rate limit of every
+// resource from the body, falling back to the headers if it has none.
+func (e *Exporter) getRates(ctx context.Context) (*RateLimits, error) {
+	u := *e.APIURL()
+	u.Path = path.Join(u.Path, "rate_limit")
+
+	resp, err := e.getHTTPResponse(ctx, u.String())
+	if err != nil {
+		return &RateLimits{}, err
+	}
+	defer resp.Body.Close()
+
+	// Triggers if rate-limiting isn't enabled on private Github Enterprise installations
+	if resp.StatusCode == 404 {
+		return &RateLimits{}, fmt.Errorf("Rate Limiting not enabled in GitHub API")
+	}
+
+	limit, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Limit"), 64)
+
+	if err != nil {
+		return &RateLimits{}, err
+	}
+
+	rem, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Remaining"), 64)
+
+	if err != nil {
+		return &RateLimits{}, err
+	}
+
+	reset, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset"), 64)
+
+	if err != nil {
+		return &RateLimits{}, err
+	}
+
+	// Older GitHub Enterprise Server releases do not send the used header
+	used, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Used"), 64)
+
+	if err != nil {
+		used = limit - rem
+	}
+
+	core := RateLimit{
+		Limit:     limit,
+		Remaining: rem,
+		Reset:     reset,
+		Used:      used,
+	}
+
+	body := struct {
+		Resources map[string]RateLimit `json:"resources"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && err != io.EOF {
+		log.Errorf("Unable to parse rate limit body, only the core rate limit is known: %v", err)
+	}
+
+	if len(body.Resources) == 0 {
+		body.Resources = map[string]RateLimit{"core": core}
+	}
+
+	return &RateLimits{
+		Limit:     limit,
+		Remaining: rem,
+		Reset:     reset,
+		Resources: body.Resources,
+	}, nil
+
+}
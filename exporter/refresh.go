@@ -0,0 +1,209 @@
+package exporter
+
+import (
+	"context"
+	"time"
+
+	"github.com/githubexporter/github-exporter/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// minTokenRenewalMargin is the least time before it expires that a GitHub App installation token is renewed
+const minTokenRenewalMargin = 5 * time.Minute
+
+// Start polls the GitHub API every RefreshInterval, stretched if need be to stay
+// within the rate limit budget, replacing the snapshot
+// served by Collect after each refresh, until the context is cancelled.
+// A Reload triggers an immediate refresh with the new configuration.
+func (e *Exporter) Start(ctx context.Context) {
+	e.configMu.Lock()
+	if e.reloaded == nil {
+		e.reloaded = make(chan struct{}, 1)
+	}
+	reloaded := e.reloaded
+	e.configMu.Unlock()
+
+	for {
+		e.refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-reloaded:
+		case <-time.After(e.refreshInterval()):
+		}
+	}
+}
+
+// Reload replaces the configuration used by subsequent refreshes, such as the
+// target list and token. A refresh already in progress completes with the
+// previous configuration and its snapshot continues to be served meanwhile,
+// while Reload returns at once, leaving the configuration to the next refresh.
+func (e *Exporter) Reload(c config.Config) {
+	e.configMu.Lock()
+	e.pending = &c
+	reloaded := e.reloaded
+	e.configMu.Unlock()
+
+	select {
+	case reloaded <- struct{}{}:
+	default:
+	}
+
+	log.Infof("Configuration reloaded, %d targets configured", len(c.TargetURLs()))
+}
+
+// refreshInterval returns the interval until the next refresh, as stretched by the last
+// refresh to stay within the rate limit budget
+func (e *Exporter) refreshInterval() time.Duration {
+	if interval := e.Snapshot().Interval; interval > 0 {
+		return interval
+	}
+	return e.RefreshInterval()
+}
+
+// refresh gathers the repositories of each target from the API, updates every
+// enabled collector and stores the result as the current snapshot. Targets
+// which fail are reported as down and keep the repositories of the last refresh
+// which scraped them. A refresh, including any retried requests, must complete
+// within the refresh interval.
+func (e *Exporter) refresh(ctx context.Context) {
+	e.refreshMu.Lock()
+	defer e.refreshMu.Unlock()
+
+	// A configuration reloaded since the last refresh is used from this refresh on, and the
+	// idle connections of the transport it replaces are closed
+	e.configMu.Lock()
+	if e.pending != nil {
+		if t := e.HTTPTransport(); t != nil && t != e.pending.HTTPTransport() {
+			t.CloseIdleConnections()
+		}
+		e.Config = *e.pending
+		e.pending = nil
+		e.client = nil
+	}
+	e.configMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, e.RefreshInterval())
+	defer cancel()
+
+	// The client is kept between refreshes to reuse connections, until the configuration is reloaded
+	if e.client == nil {
+		e.client = newHTTPClient(&e.Config, &e.requests, e.rotatable)
+	}
+
+	// Collectors are skipped if the requests they made last time would exceed the rate limit budget
+	run, skipped := e.planCollectors(enabledCollectors(&e.Config), e.Snapshot().Rates)
+
+	start := time.Now()
+	e.requests.Store(0)
+	data := []*Datum{}
+	up := map[string]bool{}
+
+	// Renew the App installation token before it can expire during this refresh. The token is
+	// obtained for a copy of the config, so that probes copying it meanwhile are not held up.
+	if e.Config.GitHubApp() {
+		c := e.Config
+		if err := c.RenewGitHubAppToken(ctx, tokenRenewalMargin(e.RefreshInterval())); err != nil {
+			log.Errorf("Error authenticating with GitHub app: %v", err)
+		}
+		e.configMu.Lock()
+		e.Config = c
+		e.configMu.Unlock()
+	}
+	e.tokens.set(e.APITokens())
+	// Scrape the repositories of each target from Github
+	if len(e.TargetURLs()) > 0 {
+		if usesGraphQL(&e.Config) {
+			data, up = e.gatherGraphQLData(ctx)
+		} else {
+			data, up = e.gatherData(ctx)
+		}
+	}
+	failed := map[string]bool{}
+	for target, ok := range up {
+		failed[target] = !ok
+	}
+
+	s := Snapshot{
+		Collectors:  run,
+		Skipped:     skipped,
+		Data:        data,
+		TargetUp:    up,
+		TokenExpiry: e.Config.GitHubAppTokenExpiry(),
+	}
+
+	// The cost of a skipped collector is remembered from the last time it was updated
+	costs := map[string]float64{targetsCost: float64(e.requests.Swap(0))}
+	for _, name := range skipped {
+		costs[name] = e.costs[name]
+	}
+
+	for _, name := range s.Collectors {
+		if err := lookupCollector(name).Update(ctx, e, &s); err != nil {
+			log.Errorf("Error updating %s collector: %v", name, err)
+		}
+		costs[name] = float64(e.requests.Swap(0))
+	}
+
+	// Skipped collectors keep serving the data of the last refresh which updated them
+	prev := e.Snapshot()
+	for _, name := range s.Skipped {
+		if c, ok := lookupCollector(name).(carrier); ok {
+			c.carry(e, prev, &s)
+		}
+	}
+
+	// Targets which could not be gathered serve the repositories of the last refresh which gathered them
+	for _, d := range prev.Data {
+		if failed[d.Target] {
+			s.Data = append(s.Data, d)
+		}
+	}
+
+	e.costs = costs
+	s.Requests = e.cost(s.Collectors)
+	s.Interval = e.nextInterval(s)
+
+	e.mu.Lock()
+	// Counts are carried forward only for targets which are still configured
+	errs := map[string]float64{}
+	for target, ok := range s.TargetUp {
+		errs[target] = e.snapshot.TargetErrors[target]
+		if !ok {
+			errs[target]++
+		}
+	}
+	s.TargetErrors = errs
+	s.RefreshedAt = time.Now()
+	s.SucceededAt = e.snapshot.SucceededAt
+	if scrapeSuccess(s.TargetUp) == 1 {
+		s.SucceededAt = s.RefreshedAt
+	}
+	s.Duration = s.RefreshedAt.Sub(start)
+	e.snapshot = s
+	e.mu.Unlock()
+
+	if s.SucceededAt != s.RefreshedAt {
+		log.Warn("GitHub data refreshed, but not every target could be scraped")
+		return
+	}
+	log.Info("GitHub data successfully refreshed")
+}
+
+// tokenRenewalMargin returns how long before it expires a token is renewed, so that it
+// remains valid throughout a refresh, which may take up to the refresh interval
+func tokenRenewalMargin(interval time.Duration) time.Duration {
+	if interval > minTokenRenewalMargin {
+		return interval
+	}
+	return minTokenRenewalMargin
+}
+
+// Snapshot returns the most recent data gathered by the refresher.
+func (e *Exporter) Snapshot() Snapshot {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.snapshot
+}
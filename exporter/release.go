@@ -0,0 +1,83 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// releaseCollector exposes the download counts of release assets for repositories configured in REPOS
+type releaseCollector struct {
+	downloads *prometheus.Desc
+}
+
+func newReleaseCollector() *releaseCollector {
+	return &releaseCollector{
+		downloads: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "release_downloads"),
+			"Download count for a given release",
+			[]string{"repo", "user", "release", "name", "tag", "created_at"}, nil,
+		),
+	}
+}
+
+func (c *releaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.downloads
+}
+
+// Update fetches the releases of each repository, unless they were gathered by the GraphQL backend
+func (c *releaseCollector) Update(ctx context.Context, e *Exporter, s *Snapshot) error {
+	if usesGraphQL(&e.Config) {
+		return nil
+	}
+	for _, d := range s.Data {
+		if !d.isRepoTarget() {
+			continue
+		}
+		if err := getReleases(ctx, e, d, &d.Releases); err != nil {
+			log.Errorf("Unable to obtain releases for target %s, Error: %s", d.Target, err)
+			s.TargetUp[d.Target] = false
+		}
+	}
+	return nil
+}
+
+// carry keeps the releases of the last refresh, unless they were gathered by the GraphQL backend
+func (c *releaseCollector) carry(e *Exporter, prev Snapshot, s *Snapshot) {
+	if usesGraphQL(&e.Config) {
+		return
+	}
+	carryData(prev, s, func(p, d *Datum) {
+		d.Releases = p.Releases
+	})
+}
+
+func (c *releaseCollector) Collect(s Snapshot, ch chan<- prometheus.Metric) {
+	for _, x := range s.Data {
+		for _, release := range x.Releases {
+			for _, asset := range release.Assets {
+				ch <- prometheus.MustNewConstMetric(c.downloads, prometheus.GaugeValue, float64(asset.Downloads), x.Name, x.Owner.Login, release.Name, asset.Name, release.Tag, asset.CreatedAt)
+			}
+		}
+	}
+}
+
+func getReleases(ctx context.Context, e *Exporter, d *Datum, data *[]Release) error {
+	releasesURL := repoURL(e, d, "releases")
+	releasesResponse := e.asyncHTTPGets(ctx, []string{releasesURL})
+
+	for _, r := range releasesResponse {
+		if r.err != nil {
+			return r.err
+		}
+		page := []Release{}
+		if err := json.Unmarshal(r.body, &page); err != nil {
+			return err
+		}
+		*data = append(*data, page...)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// repoCollector exposes the details of each repository returned for the configured targets
+type repoCollector struct {
+	stars      *prometheus.Desc
+	openIssues *prometheus.Desc
+	watchers   *prometheus.Desc
+	forks      *prometheus.Desc
+	size       *prometheus.Desc
+}
+
+func newRepoCollector() *repoCollector {
+	labels := []string{"repo", "user", "private", "fork", "archived", "license", "language"}
+
+	return &repoCollector{
+		stars: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "stars"),
+			"Total number of Stars for given repository",
+			labels, nil,
+		),
+		openIssues: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "open_issues"),
+			"Total number of open issues for given repository",
+			labels, nil,
+		),
+		watchers: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "watchers"),
+			"Total number of watchers/subscribers for given repository",
+			labels, nil,
+		),
+		forks: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "forks"),
+			"Total number of forks for given repository",
+			labels, nil,
+		),
+		size: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "size_kb"),
+			"Size in KB for given repository",
+			labels, nil,
+		),
+	}
+}
+
+func (c *repoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.stars
+	ch <- c.openIssues
+	ch <- c.watchers
+	ch <- c.forks
+	ch <- c.size
+}
+
+// Update has nothing to fetch, repositories are gathered for every target before collectors are updated
+func (c *repoCollector) Update(ctx context.Context, e *Exporter, s *Snapshot) error {
+	return nil
+}
+
+func (c *repoCollector) Collect(s Snapshot, ch chan<- prometheus.Metric) {
+	for _, x := range s.Data {
+		labels := []string{x.Name, x.Owner.Login, strconv.FormatBool(x.Private), strconv.FormatBool(x.Fork), strconv.FormatBool(x.Archived), x.License.Key, x.Language}
+
+		ch <- prometheus.MustNewConstMetric(c.stars, prometheus.GaugeValue, x.Stars, labels...)
+		ch <- prometheus.MustNewConstMetric(c.forks, prometheus.GaugeValue, x.Forks, labels...)
+		ch <- prometheus.MustNewConstMetric(c.watchers, prometheus.GaugeValue, x.Watchers, labels...)
+		ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, x.Size, labels...)
+
+		// The API counts pull requests as issues, so those counted by the pull collector are excluded.
+		// The repositories of organisations and users are only counted through GraphQL.
+		ch <- prometheus.MustNewConstMetric(c.openIssues, prometheus.GaugeValue, x.OpenIssues-x.OpenPulls, labels...)
+	}
+}
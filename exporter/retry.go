@@ -0,0 +1,208 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxRetries is the number of times a request is retried before its last response is returned
+	maxRetries = 4
+	// retryBaseDelay and retryMaxDelay bound the exponential backoff between attempts
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+	// secondaryRateLimitDelay is the minimum wait GitHub asks for after a secondary rate
+	// limit when no Retry-After header is sent
+	secondaryRateLimitDelay = time.Minute
+)
+
+// Reasons a request is retried, as reported by the retries metric
+const (
+	retryRateLimit          = "rate_limit"
+	retrySecondaryRateLimit = "secondary_rate_limit"
+	retryServerError        = "server_error"
+	retryTimeout            = "timeout"
+)
+
+// retryTransport retries requests failing with a rate limit, server error or timeout,
+// waiting as long as GitHub asks or otherwise backing off exponentially with jitter.
+// A request is not retried if the wait would pass the deadline of its context, which
+// for requests made during a refresh is the end of the refresh interval.
+type retryTransport struct {
+	// next sends each attempt, http.DefaultTransport is used when nil
+	next http.RoundTripper
+	// timeout bounds each attempt at a request, rather than the request as a whole
+	timeout time.Duration
+	// requests counts the attempts counted against the rate limit, if set
+	requests *atomic.Int64
+	// rotatable reports whether a request refused by the rate limit of its token can be sent with
+	// another token instead, so is returned at once rather than waiting for the reset, if set
+	rotatable func(req *http.Request) bool
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	url := req.URL.String()
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.attempt(req)
+
+		reason, wait := retryReason(resp, err)
+		if reason == "" || attempt == maxRetries {
+			return resp, err
+		}
+		if reason == retryRateLimit && t.rotatable != nil && t.rotatable(req) {
+			return resp, err
+		}
+		if wait < 0 {
+			wait = backoff(attempt)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		apiRetries.WithLabelValues(apiEndpoint(url), reason).Inc()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends a copy of the request bounded by the timeout, recording its outcome
+func (t *retryTransport) attempt(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	r := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	start := time.Now()
+	resp, err := next.RoundTrip(r)
+	if err != nil {
+		cancel()
+		observeAPIRequest(req.URL.String(), 0, time.Since(start))
+		return nil, err
+	}
+	observeAPIRequest(req.URL.String(), resp.StatusCode, time.Since(start))
+
+	// Neither conditional requests answered with 304 Not Modified nor reading the rate limit count against it
+	if t.requests != nil && resp.StatusCode != http.StatusNotModified && apiEndpoint(req.URL.String()) != "/rate_limit" {
+		t.requests.Add(1)
+	}
+
+	// The attempt's timeout is released once the body has been read
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryReason returns why the outcome of an attempt should be retried, if it should,
+// along with how long GitHub asked to wait, or a negative duration if it did not say
+func retryReason(resp *http.Response, err error) (string, time.Duration) {
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return retryTimeout, -1
+		}
+		return "", 0
+	}
+
+	wait, ok := retryAfter(resp)
+	if !ok {
+		wait = -1
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests:
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil && !ok {
+				wait = time.Until(time.Unix(reset, 0))
+				if wait < time.Second {
+					wait = time.Second
+				}
+			}
+			return retryRateLimit, wait
+		}
+		if ok || resp.StatusCode == http.StatusTooManyRequests || isSecondaryRateLimit(resp) {
+			if !ok {
+				wait = secondaryRateLimitDelay
+			}
+			return retrySecondaryRateLimit, wait
+		}
+	case resp.StatusCode >= 500:
+		return retryServerError, wait
+	}
+
+	return "", 0
+}
+
+// retryAfter returns the wait requested by a Retry-After header given in seconds, if there is one
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// isSecondaryRateLimit reports whether a 403 response body describes a secondary rate limit,
+// restoring the body so that it can still be read
+func isSecondaryRateLimit(resp *http.Response) bool {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{bytes.NewReader(body), resp.Body}
+	if err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(string(body)), "secondary rate limit")
+}
+
+// backoff returns a random wait of up to retryBaseDelay doubled for each previous attempt
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << attempt
+	if d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d))) + 1
+}
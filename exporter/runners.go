@@ -0,0 +1,158 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// runnersCollector exposes the self-hosted runners registered to the repositories
+// configured in REPOS and the organisations configured in ORGS
+type runnersCollector struct {
+	online  *prometheus.Desc
+	busy    *prometheus.Desc
+	runners *prometheus.Desc
+}
+
+func newRunnersCollector() *runnersCollector {
+	return &runnersCollector{
+		online: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "actions", "runner_online"),
+			"Whether the given self-hosted runner is online",
+			[]string{"target", "runner", "os", "labels"}, nil,
+		),
+		busy: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "actions", "runner_busy"),
+			"Whether the given self-hosted runner is executing a job",
+			[]string{"target", "runner", "os", "labels"}, nil,
+		),
+		runners: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "actions", "runners"),
+			"Number of self-hosted runners with the given label set by status and busy state",
+			[]string{"target", "labels", "status", "busy"}, nil,
+		),
+	}
+}
+
+func (c *runnersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.online
+	ch <- c.busy
+	ch <- c.runners
+}
+
+func (c *runnersCollector) Update(ctx context.Context, e *Exporter, s *Snapshot) error {
+	s.Runners = e.gatherRunners(ctx, s.TargetUp)
+	return nil
+}
+
+// carry keeps the runners of the last refresh registered to targets which are still configured
+func (c *runnersCollector) carry(e *Exporter, prev Snapshot, s *Snapshot) {
+	for _, r := range prev.Runners {
+		if _, ok := s.TargetUp[r.Target]; ok {
+			s.Runners = append(s.Runners, r)
+		}
+	}
+}
+
+// Collect sends the state of each runner. Every status and busy combination is reported
+// for each label set, so that groups with no online idle runners report zero.
+func (c *runnersCollector) Collect(s Snapshot, ch chan<- prometheus.Metric) {
+	type groupKey struct {
+		target, labels string
+	}
+	type stateKey struct {
+		status string
+		busy   bool
+	}
+
+	groups := map[groupKey]map[stateKey]float64{}
+
+	for _, r := range s.Runners {
+		names := []string{}
+		for _, l := range r.Labels {
+			names = append(names, l.Name)
+		}
+		sort.Strings(names)
+		labels := strings.Join(names, ",")
+
+		online, busy := 0.0, 0.0
+		if r.Status == "online" {
+			online = 1
+		}
+		if r.Busy {
+			busy = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.online, prometheus.GaugeValue, online, r.Target, r.Name, r.OS, labels)
+		ch <- prometheus.MustNewConstMetric(c.busy, prometheus.GaugeValue, busy, r.Target, r.Name, r.OS, labels)
+
+		k := groupKey{r.Target, labels}
+		if groups[k] == nil {
+			groups[k] = map[stateKey]float64{}
+		}
+		groups[k][stateKey{r.Status, r.Busy}]++
+	}
+
+	for k, states := range groups {
+		for _, status := range []string{"online", "offline"} {
+			for _, busy := range []bool{true, false} {
+				ch <- prometheus.MustNewConstMetric(c.runners, prometheus.GaugeValue, states[stateKey{status, busy}], k.target, k.labels, status, strconv.FormatBool(busy))
+			}
+		}
+	}
+}
+
+// gatherRunners - Collects the self-hosted runners registered to the configured repositories
+// and organisations. Targets whose runners cannot be listed are marked down in up.
+func (e *Exporter) gatherRunners(ctx context.Context, up map[string]bool) []Runner {
+
+	runners := []Runner{}
+	targets := map[string]string{}
+
+	for _, x := range e.Repositories() {
+		u := *e.APIURL()
+		u.Path = path.Join(u.Path, "repos", x, "actions", "runners")
+		u.RawQuery = "per_page=100"
+		targets[u.String()] = "repo:" + x
+	}
+	for _, x := range e.Organisations() {
+		u := *e.APIURL()
+		u.Path = path.Join(u.Path, "orgs", x, "actions", "runners")
+		u.RawQuery = "per_page=100"
+		targets[u.String()] = "org:" + x
+	}
+
+	urls := []string{}
+	for url := range targets {
+		urls = append(urls, url)
+	}
+
+	for _, response := range e.asyncHTTPGets(ctx, urls) {
+
+		target := targets[response.target]
+
+		if response.err != nil {
+			log.Errorf("Unable to obtain runners for target %s, Error: %v", target, response.err)
+			up[target] = false
+			continue
+		}
+
+		page := Runners{}
+		if err := json.Unmarshal(response.body, &page); err != nil {
+			log.Errorf("Unable to decode runners for target %s, Error: %v", target, err)
+			up[target] = false
+			continue
+		}
+		for _, r := range page.Runners {
+			r.Target = target
+			runners = append(runners, r)
+		}
+	}
+
+	return runners
+}
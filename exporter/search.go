@@ -0,0 +1,34 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+)
+
+// searchResults is the envelope returned by the search API, of which only the count is used
+type searchResults struct {
+	TotalCount float64 `json:"total_count"`
+}
+
+// searchIssuesCount returns the number of issues and pull requests matching the query, as counted by the
+// search API. A single result is requested, and the other pages it links to are not fetched.
+func searchIssuesCount(ctx context.Context, e *Exporter, query string) (float64, error) {
+	u := *e.APIURL()
+	u.Path = path.Join(u.Path, "search", "issues")
+	q := u.Query()
+	q.Set("q", query)
+	q.Set("per_page", "1")
+	u.RawQuery = q.Encode()
+
+	r := e.getResponse(ctx, u.String(), u.String())
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	results := searchResults{}
+	if err := json.Unmarshal(r.body, &results); err != nil {
+		return 0, err
+	}
+	return results.TotalCount, nil
+}
@@ -2,6 +2,10 @@ package exporter
 
 import (
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/githubexporter/github-exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
@@ -10,9 +14,61 @@ import (
 // Exporter is used to store Metrics data and embeds the config struct.
 // This is done so that the relevant functions have easy access to the
 // user defined runtime configuration when the Collect method is called.
+// The last good snapshot gathered by the background refresher is held
+// alongside it, so an Exporter must not be copied once in use.
+// The config, and the HTTP client built from it, are only replaced by a refresh, which holds refreshMu,
+// as are the requests made by each part of the last refresh, which are counted by the client.
+// The config is replaced while configMu is also held, so that probes can copy it without waiting
+// for a refresh to finish, and a Reload leaves the new config pending under it until the next refresh.
+// Requests are spread among the configured tokens by the token pool, which is safe for concurrent use.
 type Exporter struct {
 	APIMetrics map[string]*prometheus.Desc
 	config.Config
+
+	refreshMu sync.Mutex
+	configMu  sync.Mutex
+	pending   *config.Config
+	client    *http.Client
+	requests  atomic.Int64
+	tokens    tokenPool
+	costs     map[string]float64
+	reloaded  chan struct{}
+	mu        sync.RWMutex
+	snapshot  Snapshot
+}
+
+// Snapshot is the last good set of data gathered from the API.
+// Collect serves metrics from it rather than querying GitHub directly.
+type Snapshot struct {
+	// Collectors are the enabled collectors which were updated by the refresh
+	Collectors []string
+	// Skipped are the enabled collectors which were not updated to stay within the rate limit budget,
+	// whose data is carried forward from the previous snapshot
+	Skipped []string
+	Data    []*Datum
+	// Rates is the total of the core rate limits of every token
+	Rates *RateLimits
+	// TokenRates are the rate limits of each token, keyed by its fingerprint
+	TokenRates map[string]*RateLimits
+	Runners    []Runner
+	TargetUp   map[string]bool
+	// TargetErrors accumulates across refreshes, backing a counter
+	TargetErrors map[string]float64
+	// RefreshedAt is when the refresh finished
+	RefreshedAt time.Time
+	// SucceededAt is when a refresh last scraped every target, which unlike RefreshedAt does not
+	// advance while any target fails
+	SucceededAt time.Time
+	// Duration is how long the refresh took
+	Duration time.Duration
+	// Requests is the number of requests the refresh made
+	Requests float64
+	// Interval is the time until the next refresh
+	Interval time.Duration
+	// TokenExpiry is when the GitHub App installation token expires, zero without a GitHub App
+	TokenExpiry time.Time
+	// IssueLabels are the labels issues were counted by when the issues collector was updated
+	IssueLabels []string
 }
 
 // Data is used to store an array of Datums.
@@ -38,7 +94,29 @@ type Datum struct {
 	Watchers   float64 `json:"subscribers_count"`
 	Size       float64 `json:"size"`
 	Releases   []Release
-	Pulls      []Pull
+	// Pulls are the open pull requests, listed by the pull_lifecycle collector
+	Pulls []Pull
+	// OpenPulls is the number of open pull requests, counted by the pull collector if PullsCounted is set
+	OpenPulls    float64 `json:"-"`
+	PullsCounted bool    `json:"-"`
+	// ClosedPulls are the pull requests closed within the lookback window, fetched by the pull_lifecycle collector
+	ClosedPulls []Pull `json:"-"`
+	// Issues are the open issues, excluding pull requests, fetched by the issues collector, nil unless fetched
+	Issues []Issue `json:"-"`
+	// ClosedIssues counts the closed issues with each label counted by the issues collector, keyed by label,
+	// with the total under the empty label
+	ClosedIssues map[string]float64 `json:"-"`
+	// Traffic is the traffic of the last 14 days, fetched by the traffic collector
+	Traffic      *Traffic `json:"-"`
+	WorkflowRuns []WorkflowRun
+	// Target is the name of the target the repository was gathered from
+	Target string `json:"-"`
+}
+
+// isRepoTarget reports whether the repository was configured individually in REPOS,
+// rather than found through an organisation or user
+func (d *Datum) isRepoTarget() bool {
+	return strings.HasPrefix(d.Target, "repo:")
 }
 
 type Release struct {
@@ -48,10 +126,142 @@ type Release struct {
 }
 
 type Pull struct {
-	Url  string `json:"url"`
+	Url    string `json:"url"`
+	Number int    `json:"number"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Draft bool `json:"draft"`
+	Base  struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ClosedAt  time.Time `json:"closed_at"`
+	MergedAt  time.Time `json:"merged_at"`
+	Reviews   []Review
+}
+
+type Review struct {
 	User struct {
 		Login string `json:"login"`
 	} `json:"user"`
+	State       string    `json:"state"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+type Issue struct {
+	Number int `json:"number"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Assignees []struct {
+		Login string `json:"login"`
+	} `json:"assignees"`
+	CreatedAt time.Time `json:"created_at"`
+	// PullRequest is set on the pull requests the issues endpoint also lists
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+// Traffic holds the views, clones and most popular referrers and paths of a repository, as
+// retained by GitHub for 14 days
+type Traffic struct {
+	Views     TrafficCounts
+	Clones    TrafficCounts
+	Referrers []TrafficReferrer
+	Paths     []TrafficPath
+}
+
+// TrafficCounts is the envelope returned by the traffic views and clones endpoints
+type TrafficCounts struct {
+	Count   float64      `json:"count"`
+	Uniques float64      `json:"uniques"`
+	Views   []TrafficDay `json:"views"`
+	Clones  []TrafficDay `json:"clones"`
+}
+
+type TrafficDay struct {
+	Timestamp time.Time `json:"timestamp"`
+	Count     float64   `json:"count"`
+	Uniques   float64   `json:"uniques"`
+}
+
+type TrafficReferrer struct {
+	Referrer string  `json:"referrer"`
+	Count    float64 `json:"count"`
+	Uniques  float64 `json:"uniques"`
+}
+
+type TrafficPath struct {
+	Path    string  `json:"path"`
+	Title   string  `json:"title"`
+	Count   float64 `json:"count"`
+	Uniques float64 `json:"uniques"`
+}
+
+// WorkflowRuns is the envelope returned by the Actions workflow runs endpoint
+type WorkflowRuns struct {
+	TotalCount   int           `json:"total_count"`
+	WorkflowRuns []WorkflowRun `json:"workflow_runs"`
+}
+
+type WorkflowRun struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	HeadBranch   string    `json:"head_branch"`
+	Event        string    `json:"event"`
+	Status       string    `json:"status"`
+	Conclusion   string    `json:"conclusion"`
+	CreatedAt    time.Time `json:"created_at"`
+	RunStartedAt time.Time `json:"run_started_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Jobs         []WorkflowJob
+}
+
+// WorkflowJobs is the envelope returned by the Actions workflow run jobs endpoint
+type WorkflowJobs struct {
+	TotalCount int           `json:"total_count"`
+	Jobs       []WorkflowJob `json:"jobs"`
+}
+
+type WorkflowJob struct {
+	ID          int64          `json:"id"`
+	Name        string         `json:"name"`
+	Status      string         `json:"status"`
+	Conclusion  string         `json:"conclusion"`
+	Labels      []string       `json:"labels"`
+	CreatedAt   time.Time      `json:"created_at"`
+	StartedAt   time.Time      `json:"started_at"`
+	CompletedAt time.Time      `json:"completed_at"`
+	Steps       []WorkflowStep `json:"steps"`
+}
+
+type WorkflowStep struct {
+	Name        string    `json:"name"`
+	Number      int       `json:"number"`
+	Status      string    `json:"status"`
+	Conclusion  string    `json:"conclusion"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// Runners is the envelope returned by the Actions self-hosted runners endpoints
+type Runners struct {
+	TotalCount int      `json:"total_count"`
+	Runners    []Runner `json:"runners"`
+}
+
+type Runner struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	OS     string `json:"os"`
+	Status string `json:"status"`
+	Busy   bool   `json:"busy"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	// Target is the repository or organisation the runner is registered to
+	Target string `json:"-"`
 }
 
 type Asset struct {
@@ -62,16 +272,28 @@ type Asset struct {
 }
 
 // RateLimits is used to store rate limit data into a struct
-// This data is later represented as a metric, captured at the end of a scrape
+// This data is later represented as a metric, captured at the end of a scrape.
+// Limit, Remaining and Reset describe the core rate limit, while Resources holds
+// every rate limit reported by the API keyed by resource, such as search or graphql.
 type RateLimits struct {
 	Limit     float64
 	Remaining float64
 	Reset     float64
+	Resources map[string]RateLimit
+}
+
+// RateLimit is the rate limit of a single resource, as reported by the /rate_limit endpoint
+type RateLimit struct {
+	Limit     float64 `json:"limit"`
+	Remaining float64 `json:"remaining"`
+	Reset     float64 `json:"reset"`
+	Used      float64 `json:"used"`
 }
 
 // Response struct is used to store http.Response and associated data
 type Response struct {
 	url      string
+	target   string
 	response *http.Response
 	body     []byte
 	err      error
@@ -0,0 +1,146 @@
+package exporter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenPool spreads requests among the configured tokens, tracking the core rate
+// limit remaining for each from the headers of the responses to its requests
+type tokenPool struct {
+	mu     sync.Mutex
+	tokens []*tokenState
+}
+
+type tokenState struct {
+	token     string
+	limit     float64
+	remaining float64
+	reset     time.Time
+	// known is false until a response to a request made with the token has been seen
+	known bool
+}
+
+type tokenKey struct{}
+
+// withToken returns a context whose requests are made with the given token, rather than one picked from the pool
+func withToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// tokenFingerprint identifies a token in metrics and logs without revealing it
+func tokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// set replaces the tokens in the pool, keeping what is known of those already in it
+func (p *tokenPool) set(tokens []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	known := map[string]*tokenState{}
+	for _, t := range p.tokens {
+		known[t.token] = t
+	}
+
+	p.tokens = nil
+	for _, token := range tokens {
+		t, ok := known[token]
+		if !ok {
+			t = &tokenState{token: token}
+		}
+		p.tokens = append(p.tokens, t)
+	}
+}
+
+// list returns the tokens in the pool
+func (p *tokenPool) list() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tokens := []string{}
+	for _, t := range p.tokens {
+		tokens = append(tokens, t.token)
+	}
+	return tokens
+}
+
+// pick returns the token with the most requests remaining, along with how many remain.
+// Tokens which have not been used yet are tried first, and a token whose rate limit has
+// reset is assumed to have its full limit again. An empty pool returns no token.
+func (p *tokenPool) pick() (string, float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	best, headroom := "", math.Inf(-1)
+	for _, t := range p.tokens {
+		if h := t.headroom(now); h > headroom {
+			best, headroom = t.token, h
+		}
+	}
+	return best, headroom
+}
+
+// hasOther reports whether a token other than the given one has requests remaining
+func (p *tokenPool) hasOther(token string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range p.tokens {
+		if t.token != token && t.headroom(now) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tokenState) headroom(now time.Time) float64 {
+	switch {
+	case !t.known:
+		return math.Inf(1)
+	case !now.Before(t.reset):
+		return t.limit
+	default:
+		return t.remaining
+	}
+}
+
+// observe records the core rate limit reported by a response to a request made with the token
+func (p *tokenPool) observe(token string, resp *http.Response) {
+	if resource := resp.Header.Get("X-RateLimit-Resource"); resource != "" && resource != "core" {
+		return
+	}
+	limit, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Limit"), 64)
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Remaining"), 64)
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, t := range p.tokens {
+		if t.token == token {
+			t.limit, t.remaining, t.reset, t.known = limit, remaining, time.Unix(reset, 0), true
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// trafficCollector exposes the views, clones and most popular referrers and paths of repositories
+// configured in REPOS. GitHub only retains traffic for 14 days and only shares it with tokens
+// which have push access to the repository.
+type trafficCollector struct {
+	views            *prometheus.Desc
+	visitors         *prometheus.Desc
+	clones           *prometheus.Desc
+	cloners          *prometheus.Desc
+	referrerViews    *prometheus.Desc
+	referrerVisitors *prometheus.Desc
+	pathViews        *prometheus.Desc
+	pathVisitors     *prometheus.Desc
+}
+
+func newTrafficCollector() *trafficCollector {
+	return &trafficCollector{
+		views: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "traffic_views_daily"),
+			"Number of views of the given repository on the last complete day in UTC",
+			[]string{"repo", "user"}, nil,
+		),
+		visitors: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "traffic_unique_visitors_daily"),
+			"Number of unique visitors to the given repository on the last complete day in UTC",
+			[]string{"repo", "user"}, nil,
+		),
+		clones: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "traffic_clones_daily"),
+			"Number of clones of the given repository on the last complete day in UTC",
+			[]string{"repo", "user"}, nil,
+		),
+		cloners: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "traffic_unique_cloners_daily"),
+			"Number of unique cloners of the given repository on the last complete day in UTC",
+			[]string{"repo", "user"}, nil,
+		),
+		referrerViews: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "traffic_referrer_views"),
+			"Number of views of the given repository from its top 10 referrers over the last 14 days",
+			[]string{"repo", "user", "referrer"}, nil,
+		),
+		referrerVisitors: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "traffic_referrer_unique_visitors"),
+			"Number of unique visitors to the given repository from its top 10 referrers over the last 14 days",
+			[]string{"repo", "user", "referrer"}, nil,
+		),
+		pathViews: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "traffic_path_views"),
+			"Number of views of the top 10 paths of the given repository over the last 14 days",
+			[]string{"repo", "user", "path"}, nil,
+		),
+		pathVisitors: prometheus.NewDesc(
+			prometheus.BuildFQName("github", "repo", "traffic_path_unique_visitors"),
+			"Number of unique visitors to the top 10 paths of the given repository over the last 14 days",
+			[]string{"repo", "user", "path"}, nil,
+		),
+	}
+}
+
+func (c *trafficCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.views
+	ch <- c.visitors
+	ch <- c.clones
+	ch <- c.cloners
+	ch <- c.referrerViews
+	ch <- c.referrerVisitors
+	ch <- c.pathViews
+	ch <- c.pathVisitors
+}
+
+func (c *trafficCollector) Update(ctx context.Context, e *Exporter, s *Snapshot) error {
+	for _, d := range s.Data {
+		if !d.isRepoTarget() {
+			continue
+		}
+		traffic := &Traffic{}
+		if err := getTraffic(ctx, e, d, traffic); err != nil {
+			log.Errorf("Unable to obtain traffic for target %s, Error: %s", d.Target, err)
+			continue
+		}
+		d.Traffic = traffic
+	}
+	return nil
+}
+
+// carry keeps the traffic of the last refresh
+func (c *trafficCollector) carry(e *Exporter, prev Snapshot, s *Snapshot) {
+	carryData(prev, s, func(p, d *Datum) {
+		d.Traffic = p.Traffic
+	})
+}
+
+// Collect sends the views and clones of each repository on the day before the refresh, as the
+// current day is incomplete, and the views of its most popular referrers and paths. Days
+// without traffic are omitted by the API, so are reported as zero.
+func (c *trafficCollector) Collect(s Snapshot, ch chan<- prometheus.Metric) {
+	day := s.RefreshedAt.UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)
+
+	for _, x := range s.Data {
+		if x.Traffic == nil {
+			continue
+		}
+
+		views := trafficOn(x.Traffic.Views.Views, day)
+		clones := trafficOn(x.Traffic.Clones.Clones, day)
+		ch <- prometheus.MustNewConstMetric(c.views, prometheus.GaugeValue, views.Count, x.Name, x.Owner.Login)
+		ch <- prometheus.MustNewConstMetric(c.visitors, prometheus.GaugeValue, views.Uniques, x.Name, x.Owner.Login)
+		ch <- prometheus.MustNewConstMetric(c.clones, prometheus.GaugeValue, clones.Count, x.Name, x.Owner.Login)
+		ch <- prometheus.MustNewConstMetric(c.cloners, prometheus.GaugeValue, clones.Uniques, x.Name, x.Owner.Login)
+
+		for _, r := range x.Traffic.Referrers {
+			ch <- prometheus.MustNewConstMetric(c.referrerViews, prometheus.GaugeValue, r.Count, x.Name, x.Owner.Login, r.Referrer)
+			ch <- prometheus.MustNewConstMetric(c.referrerVisitors, prometheus.GaugeValue, r.Uniques, x.Name, x.Owner.Login, r.Referrer)
+		}
+		for _, p := range x.Traffic.Paths {
+			ch <- prometheus.MustNewConstMetric(c.pathViews, prometheus.GaugeValue, p.Count, x.Name, x.Owner.Login, p.Path)
+			ch <- prometheus.MustNewConstMetric(c.pathVisitors, prometheus.GaugeValue, p.Uniques, x.Name, x.Owner.Login, p.Path)
+		}
+	}
+}
+
+// trafficOn returns the traffic of the day starting at the given time, or none if there was none
+func trafficOn(days []TrafficDay, day time.Time) TrafficDay {
+	for _, d := range days {
+		if d.Timestamp.Equal(day) {
+			return d
+		}
+	}
+	return TrafficDay{Timestamp: day}
+}
+
+// getTraffic fetches the daily views and clones, and the most popular referrers and paths, of a repository
+func getTraffic(ctx context.Context, e *Exporter, d *Datum, data *Traffic) error {
+	viewsURL := repoURL(e, d, "traffic", "views")
+	clonesURL := repoURL(e, d, "traffic", "clones")
+	referrersURL := repoURL(e, d, "traffic", "popular", "referrers")
+	pathsURL := repoURL(e, d, "traffic", "popular", "paths")
+
+	targets := map[string]interface{}{
+		viewsURL:     &data.Views,
+		clonesURL:    &data.Clones,
+		referrersURL: &data.Referrers,
+		pathsURL:     &data.Paths,
+	}
+
+	trafficResponse := e.asyncHTTPGets(ctx, []string{viewsURL, clonesURL, referrersURL, pathsURL})
+
+	for _, r := range trafficResponse {
+		if r.err != nil {
+			return r.err
+		}
+		if err := json.Unmarshal(r.body, targets[r.target]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
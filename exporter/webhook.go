@@ -0,0 +1,150 @@
+package exporter
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The webhook metrics count events as GitHub delivers them, complementing the gauges
+// refreshed from the API. They are shared by every exporter, so are registered once here.
+var (
+	webhookEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName("github", "webhook", "events_total"),
+			Help: "Total number of webhook events received by event, action and repository",
+		},
+		[]string{"event", "action", "repo", "user"},
+	)
+	webhookPushCommits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName("github", "webhook", "push_commits_total"),
+			Help: "Total number of commits pushed by repository",
+		},
+		[]string{"repo", "user"},
+	)
+	webhookPullRequestLeadTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    prometheus.BuildFQName("github", "webhook", "pull_request_lead_time_seconds"),
+			Help:    "Time from the creation of a pull request until it was merged by repository",
+			Buckets: leadTimeBuckets,
+		},
+		[]string{"repo", "user"},
+	)
+	webhookWorkflowRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName("github", "webhook", "workflow_runs_total"),
+			Help: "Total number of completed GitHub Actions workflow runs by repository, workflow and conclusion",
+		},
+		[]string{"repo", "user", "workflow", "conclusion"},
+	)
+	webhookWorkflowRunDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    prometheus.BuildFQName("github", "webhook", "workflow_run_duration_seconds"),
+			Help:    "Duration of completed GitHub Actions workflow runs by repository and workflow",
+			Buckets: durationBuckets,
+		},
+		[]string{"repo", "user", "workflow"},
+	)
+	webhookReleases = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName("github", "webhook", "releases_published_total"),
+			Help: "Total number of releases published by repository",
+		},
+		[]string{"repo", "user"},
+	)
+	webhookIssues = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName("github", "webhook", "issues_total"),
+			Help: "Total number of issues opened or closed by repository and action",
+		},
+		[]string{"repo", "user", "action"},
+	)
+	webhookIssueTimeToClose = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    prometheus.BuildFQName("github", "webhook", "issue_time_to_close_seconds"),
+			Help:    "Time from the creation of an issue until it was closed by repository",
+			Buckets: issueAgeBuckets,
+		},
+		[]string{"repo", "user"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(webhookEvents, webhookPushCommits, webhookPullRequestLeadTime, webhookWorkflowRuns, webhookWorkflowRunDuration,
+		webhookReleases, webhookIssues, webhookIssueTimeToClose)
+}
+
+// webhookPayload holds the fields of the webhook payloads the metrics are derived from
+type webhookPayload struct {
+	Action     string `json:"action"`
+	Repository struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+	Commits     []json.RawMessage `json:"commits"`
+	PullRequest struct {
+		Merged    bool      `json:"merged"`
+		CreatedAt time.Time `json:"created_at"`
+		MergedAt  time.Time `json:"merged_at"`
+	} `json:"pull_request"`
+	WorkflowRun WorkflowRun `json:"workflow_run"`
+	Issue       struct {
+		CreatedAt time.Time `json:"created_at"`
+		ClosedAt  time.Time `json:"closed_at"`
+	} `json:"issue"`
+}
+
+// HandleWebhook records a webhook event, named by its X-GitHub-Event header, from its
+// JSON payload. Every event is counted, while push, pull_request, workflow_run, release and
+// issues events also record the commits pushed, the lead time of merged pull requests, the
+// duration of completed workflow runs, the releases published and the issues opened and
+// closed, along with their time to close. An error is returned if the payload cannot be parsed.
+func HandleWebhook(event string, payload []byte) error {
+	var p webhookPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	repo, user := p.Repository.Name, p.Repository.Owner.Login
+	webhookEvents.WithLabelValues(event, p.Action, repo, user).Inc()
+
+	switch event {
+	case "push":
+		webhookPushCommits.WithLabelValues(repo, user).Add(float64(len(p.Commits)))
+	case "pull_request":
+		pr := p.PullRequest
+		if p.Action == "closed" && pr.Merged && !pr.MergedAt.IsZero() {
+			webhookPullRequestLeadTime.WithLabelValues(repo, user).Observe(pr.MergedAt.Sub(pr.CreatedAt).Seconds())
+		}
+	case "workflow_run":
+		run := p.WorkflowRun
+		if p.Action != "completed" {
+			break
+		}
+		webhookWorkflowRuns.WithLabelValues(repo, user, run.Name, run.Conclusion).Inc()
+		if !run.RunStartedAt.IsZero() {
+			webhookWorkflowRunDuration.WithLabelValues(repo, user, run.Name).Observe(run.UpdatedAt.Sub(run.RunStartedAt).Seconds())
+		}
+	case "release":
+		if p.Action == "published" {
+			webhookReleases.WithLabelValues(repo, user).Inc()
+		}
+	case "issues":
+		issue := p.Issue
+		switch p.Action {
+		case "opened":
+			webhookIssues.WithLabelValues(repo, user, p.Action).Inc()
+		case "closed":
+			webhookIssues.WithLabelValues(repo, user, p.Action).Inc()
+			if !issue.ClosedAt.IsZero() {
+				webhookIssueTimeToClose.WithLabelValues(repo, user).Observe(issue.ClosedAt.Sub(issue.CreatedAt).Seconds())
+			}
+		}
+	}
+
+	return nil
+}
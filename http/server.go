@@ -1,42 +1,370 @@
 package http
 
 import (
-	"log"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/githubexporter/github-exporter/config"
 	"github.com/githubexporter/github-exporter/exporter"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
 )
 
+// configWatchInterval is how often the configuration file is checked for changes
+const configWatchInterval = 30 * time.Second
+
+// maxWebhookPayload is the largest webhook payload accepted, GitHub caps payloads at 25 MB
+const maxWebhookPayload = 25 << 20
+
+// installationsInterval is how often the installations of GitHub Apps are listed again, when any are discovered
+const installationsInterval = 10 * time.Minute
+
+// maxConcurrentProbes is the number of probes served at once, further probes wait for one to finish
+const maxConcurrentProbes = 10
+
 type Server struct {
 	Handler  http.Handler
-	exporter exporter.Exporter
+	exporter *exporter.Exporter
+	// primary is the name of the first configured target, probed when no module is given
+	primary string
+	probes  chan struct{}
+
+	loadMu    sync.Mutex
+	mu        sync.Mutex
+	ctx       context.Context
+	exporters map[string]*exporter.Exporter
+	cancels   map[string]context.CancelFunc
+	// discovers is whether any target was discovered as an installation of a GitHub App
+	discovers bool
 }
 
-func NewServer(exporter exporter.Exporter) *Server {
+// NewServer registers each exporter and serves their metrics. Exporters for named
+// targets from a configuration file have their metrics labelled with the target name.
+// Application wide settings such as the listen port are taken from the first exporter.
+func NewServer(exporters ...*exporter.Exporter) *Server {
 	r := http.NewServeMux()
+	primary := exporters[0]
+
+	s := &Server{
+		Handler:   r,
+		exporter:  primary,
+		primary:   primary.Name(),
+		probes:    make(chan struct{}, maxConcurrentProbes),
+		exporters: map[string]*exporter.Exporter{},
+		cancels:   map[string]context.CancelFunc{},
+	}
 
 	// Register Metrics from each of the endpoints
 	// This invokes the Collect method through the prometheus client libraries.
-	prometheus.MustRegister(&exporter)
+	for _, e := range exporters {
+		registerer(e.Name()).MustRegister(e)
+		s.exporters[e.Name()] = e
+		s.discovers = s.discovers || e.Installation() != ""
+	}
 
-	r.Handle(exporter.MetricsPath(), promhttp.Handler())
+	r.Handle(primary.MetricsPath(), promhttp.Handler())
+	r.HandleFunc("/probe", s.probe)
+	if secret := primary.WebhookSecret(); secret != "" {
+		r.Handle("/webhook", webhookHandler(secret))
+	}
+	r.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "This endpoint requires a POST request", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := s.Reload(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to reload configuration: %s", err), http.StatusInternalServerError)
+			return
+		}
+	})
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`<html>
 		                <head><title>Github Exporter</title></head>
 		                <body>
 		                   <h1>GitHub Prometheus Metrics Exporter</h1>
 						   <p>For more information, visit <a href=https://github.com/githubexporter/github-exporter>GitHub</a></p>
-		                   <p><a href='` + exporter.MetricsPath() + `'>Metrics</a></p>
+		                   <p><a href='` + primary.MetricsPath() + `'>Metrics</a></p>
 		                   </body>
 		                </html>
 		              `))
 	})
 
-	return &Server{Handler: r, exporter: exporter}
+	return s
 }
 
+// Start launches the background refresher of each exporter, reloads the
+// configuration on SIGHUP or when the configuration file changes, and serves HTTP.
 func (s *Server) Start() {
+	s.mu.Lock()
+	s.ctx = context.Background()
+	for name, e := range s.exporters {
+		s.run(name, e)
+	}
+	s.mu.Unlock()
+
+	go s.reloadOnSignal()
+	go s.discoverPeriodically()
+	go config.Watch(s.ctx, configWatchInterval, s.reload)
+
 	log.Fatal(http.ListenAndServe(":"+s.exporter.ListenPort(), s.Handler))
 }
+
+// Reload re-reads the configuration. Exporters of existing targets are updated
+// in place, so that their last snapshot continues to be served, while exporters
+// are created and removed for targets added to or removed from the configuration.
+func (s *Server) Reload() error {
+	return s.load(true)
+}
+
+// DiscoverInstallations lists the installations of each GitHub App again, creating and removing
+// exporters for installations added or removed since, while leaving other exporters as they are
+func (s *Server) DiscoverInstallations() error {
+	return s.load(false)
+}
+
+// load re-reads the configuration, creating and removing exporters for targets which were
+// added or removed, and updating the exporters of existing targets if reload is set. Loads are
+// serialized by loadMu, while s.mu is only held to read and update the exporters, so that probes
+// are not held up by exporters which are busy refreshing.
+func (s *Server) load(reload bool) error {
+	configs, err := config.Load()
+	if err != nil {
+		return err
+	}
+	for _, c := range configs {
+		if err := exporter.CheckCollectors(c); err != nil {
+			return fmt.Errorf("target %s: %v", c.Name(), err)
+		}
+	}
+
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+
+	s.mu.Lock()
+	current := maps.Clone(s.exporters)
+	s.mu.Unlock()
+
+	added := map[string]*exporter.Exporter{}
+	discovers := false
+
+	for _, c := range configs {
+		discovers = discovers || c.Installation() != ""
+
+		if e, ok := current[c.Name()]; ok {
+			delete(current, c.Name())
+			if reload {
+				e.Reload(c)
+			}
+			continue
+		}
+
+		e := &exporter.Exporter{
+			APIMetrics: exporter.AddMetrics(),
+			Config:     c,
+		}
+		if err := registerer(c.Name()).Register(e); err != nil {
+			for name, e := range added {
+				registerer(name).Unregister(e)
+			}
+			return fmt.Errorf("unable to register target %s: %v", c.Name(), err)
+		}
+		added[c.Name()] = e
+	}
+
+	// The exporters left in current are those of targets which were removed
+	s.mu.Lock()
+	s.discovers = discovers
+	for name, e := range added {
+		s.exporters[name] = e
+		if s.ctx != nil {
+			s.run(name, e)
+		}
+		log.Infof("Target %s added", name)
+	}
+	for name := range current {
+		if cancel, ok := s.cancels[name]; ok {
+			cancel()
+			delete(s.cancels, name)
+		}
+		delete(s.exporters, name)
+		log.Infof("Target %s removed", name)
+	}
+	s.mu.Unlock()
+
+	for name, e := range current {
+		registerer(name).Unregister(e)
+	}
+
+	return nil
+}
+
+// probe scrapes the target given by the target parameter once and serves its metrics, using the
+// API, credentials and collectors of the configured target named by the module parameter, or of
+// the first configured target if there is none. The scrape is bounded by the Prometheus timeout,
+// which includes any wait for one of the probes already in progress to finish.
+func (s *Server) probe(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	// The first target may have been reconfigured or removed since the server started
+	module := r.URL.Query().Get("module")
+	s.mu.Lock()
+	e, ok := s.exporters[module]
+	if module == "" {
+		e, ok = s.exporters[s.primary]
+	}
+	s.mu.Unlock()
+	if !ok && module == "" {
+		http.Error(w, fmt.Sprintf("The first configured target %q was removed, a module parameter is required", s.primary), http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", module), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(seconds*float64(time.Second)))
+		defer cancel()
+	}
+
+	select {
+	case s.probes <- struct{}{}:
+		defer func() { <-s.probes }()
+	case <-ctx.Done():
+		http.Error(w, "Too many probes in progress", http.StatusServiceUnavailable)
+		return
+	}
+
+	p, err := e.Probe(ctx, target)
+	if err != nil && ctx.Err() != nil {
+		http.Error(w, fmt.Sprintf("Probe of %s timed out", target), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(p)
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// webhookHandler records the webhook events delivered by GitHub, rejecting any whose
+// X-Hub-Signature-256 header is not the HMAC-SHA256 of the body keyed with the secret
+func webhookHandler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "This endpoint requires a POST request", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayload))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unable to read payload: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		if !validSignature(secret, body, r.Header.Get("X-Hub-Signature-256")) {
+			log.Warnf("Rejected webhook delivery %s with an invalid signature", r.Header.Get("X-GitHub-Delivery"))
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		event := r.Header.Get("X-GitHub-Event")
+		if err := exporter.HandleWebhook(event, body); err != nil {
+			http.Error(w, fmt.Sprintf("Unable to parse %s event: %s", event, err), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// validSignature reports whether signature, in the form "sha256=<hex>", is the HMAC-SHA256 of the body
+func validSignature(secret string, body []byte, signature string) bool {
+	sum, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sum)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// reload calls Reload, logging any error
+func (s *Server) reload() {
+	if err := s.Reload(); err != nil {
+		log.Errorf("Error reloading configuration, Error: %v", err)
+	}
+}
+
+// discoverPeriodically calls DiscoverInstallations every installationsInterval, while any
+// target is an installation of a GitHub App, logging any error
+func (s *Server) discoverPeriodically() {
+	ticker := time.NewTicker(installationsInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		discovers := s.discovers
+		s.mu.Unlock()
+
+		if !discovers {
+			continue
+		}
+		if err := s.DiscoverInstallations(); err != nil {
+			log.Errorf("Error discovering GitHub App installations, Error: %v", err)
+		}
+	}
+}
+
+// reloadOnSignal reloads the configuration each time a SIGHUP is received
+func (s *Server) reloadOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		log.Info("Received SIGHUP, reloading configuration")
+		s.reload()
+	}
+}
+
+// run starts the refresher of an exporter, must be called with s.mu held
+func (s *Server) run(name string, e *exporter.Exporter) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.cancels[name] = cancel
+	go e.Start(ctx)
+}
+
+// registerer returns the registerer for a target, labelling the metrics of named targets
+func registerer(name string) prometheus.Registerer {
+	if name == "" {
+		return prometheus.DefaultRegisterer
+	}
+	return prometheus.WrapRegistererWith(prometheus.Labels{"source": name}, prometheus.DefaultRegisterer)
+}
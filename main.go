@@ -10,24 +10,37 @@ import (
 )
 
 var (
-	log            *logrus.Logger
-	applicationCfg conf.Config
-	mets           map[string]*prometheus.Desc
+	log             *logrus.Logger
+	applicationCfgs []conf.Config
+	mets            map[string]*prometheus.Desc
 )
 
 func init() {
-	applicationCfg = conf.Init()
+	var err error
+	applicationCfgs, err = conf.Load()
+	if err != nil {
+		logrus.Fatalf("Error loading configuration: %v", err)
+	}
+	for _, cfg := range applicationCfgs {
+		if err := exporter.CheckCollectors(cfg); err != nil {
+			logrus.Fatalf("Error loading configuration for target %s: %v", cfg.Name(), err)
+		}
+	}
 	mets = exporter.AddMetrics()
-	log = logger.Start(&applicationCfg)
+	log = logger.Start(&applicationCfgs[0])
 }
 
 func main() {
 	log.Info("Starting Exporter")
 
-	exp := exporter.Exporter{
-		APIMetrics: mets,
-		Config:     applicationCfg,
+	exps := []*exporter.Exporter{}
+
+	for _, cfg := range applicationCfgs {
+		exps = append(exps, &exporter.Exporter{
+			APIMetrics: mets,
+			Config:     cfg,
+		})
 	}
 
-	http.NewServer(exp).Start()
+	http.NewServer(exps...).Start()
 }
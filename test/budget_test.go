@@ -0,0 +1,91 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/githubexporter/github-exporter/exporter"
+	web "github.com/githubexporter/github-exporter/http"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinfletcher/apitest"
+)
+
+func TestGithubExporterSkipsCollectorsOverBudget(t *testing.T) {
+	// Only 4 requests remain, 3 of which are reserved, while a refresh makes 3
+	files := fakeGithubHandler(map[string]string{
+		"/repos/myOrg/myRepo":          "testdata/my_repo_response.json",
+		"/repos/myOrg/myRepo/releases": "testdata/releases_response.json",
+		"/search/issues":               "testdata/search_pulls_response.json",
+	})
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rate_limit" {
+			w.Header().Set("X-RateLimit-Limit", "5000")
+			w.Header().Set("X-RateLimit-Remaining", "4")
+			w.Header().Set("X-RateLimit-Reset", reset)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("RATE_LIMIT_RESERVE", "3")
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("RATE_LIMIT_RESERVE")
+
+	conf := withConfig("myOrg/myRepo")
+	exp := &exporter.Exporter{
+		APIMetrics: exporter.AddMetrics(),
+		Config:     conf,
+	}
+	server := web.NewServer(exp)
+	defer prometheus.Unregister(exp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exp.Start(ctx)
+
+	// The first refresh runs every collector, as their cost is not known yet
+	waitForSnapshot(t, exp, func(s exporter.Snapshot) bool { return s.Rates != nil })
+	exp.Reload(conf)
+	waitForSnapshot(t, exp, func(s exporter.Snapshot) bool { return len(s.Skipped) > 0 })
+
+	apitest.New().
+		Handler(server.Handler).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_exporter_collector_skipped{collector="release"} 1`)).
+		Assert(bodyContains(`github_exporter_collector_skipped{collector="pull"} 1`)).
+		Assert(bodyContains(`github_exporter_collector_skipped{collector="repo"} 0`)).
+		Assert(bodyContains(`github_exporter_refresh_requests 1`)).
+		Assert(bodyContains(`github_repo_stars{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 120`)).
+		// The skipped collectors serve the data of the first refresh, which still excludes pull requests from issues
+		Assert(bodyContains(`github_repo_pull_request_count{repo="myRepo",user="myOrg"} 3`)).
+		Assert(bodyContains(`github_repo_open_issues{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 2`)).
+		Assert(bodyContains(`github_repo_release_downloads{created_at="2019-02-28T08:25:53Z",name="myRepo_1.3.0_windows_amd64.tar.gz",release="1.3.0",repo="myRepo",tag="1.3.0",user="myOrg"} 21`)).
+		Status(http.StatusOK).
+		End()
+
+	// With no budget left, the next refresh waits for the rate limit to reset
+	if interval := exp.Snapshot().Interval; interval < 50*time.Minute {
+		t.Errorf("expected the refresh to be postponed until the rate limit resets, got %s", interval)
+	}
+}
+
+// waitForSnapshot waits for the background refresher to store a snapshot satisfying done
+func waitForSnapshot(t *testing.T, exp *exporter.Exporter, done func(exporter.Snapshot) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done(exp.Snapshot()) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a refresh")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/githubexporter/github-exporter/config"
+	"github.com/githubexporter/github-exporter/exporter"
+)
+
+func TestConfigFile(t *testing.T) {
+	configs, err := config.LoadFile("testdata/config/valid.yml")
+	if err != nil {
+		t.Fatalf("unexpected error loading configuration: %v", err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(configs))
+	}
+
+	public, enterprise := configs[0], configs[1]
+
+	if public.Name() != "public" || public.APIToken() != "12345" {
+		t.Errorf("unexpected public target: name %q, token %q", public.Name(), public.APIToken())
+	}
+	if public.RefreshInterval() != 5*time.Minute {
+		t.Errorf("expected refresh interval of 5m, got %s", public.RefreshInterval())
+	}
+	if !public.CollectorEnabled("actions", false) || !public.CollectorEnabled("runners", false) || public.CollectorEnabled("actions_jobs", false) {
+		t.Errorf("unexpected collectors enabled for public target")
+	}
+	if len(public.TargetURLs()) != 2 {
+		t.Errorf("expected 2 target URLs for public target, got %v", public.TargetURLs())
+	}
+
+	if enterprise.APIToken() != "abcdef" {
+		t.Errorf("expected token to be read from token_file, got %q", enterprise.APIToken())
+	}
+	if got := enterprise.TargetURLs(); len(got) != 1 || !strings.HasPrefix(got[0], "https://github.example.com/api/v3/users/octocat/repos") {
+		t.Errorf("unexpected enterprise target URLs: %v", got)
+	}
+}
+
+func TestConfigFileValidation(t *testing.T) {
+	cases := map[string]string{
+		"no targets":       "targets: []",
+		"unknown field":    "targets:\n  - repos: [a/b]\n    colectors: {actions: true}",
+		"collector list":   "targets:\n  - collectors: [actions]",
+		"missing name":     "targets:\n  - repos: [a/b]\n  - repos: [c/d]",
+		"duplicate name":   "targets:\n  - name: a\n  - name: a",
+		"relative api url": "targets:\n  - api_url: github.example.com",
+		"bad interval":     "targets:\n  - refresh_interval: often",
+		"unknown backend":  "targets:\n  - api_backend: soap",
+		"negative reserve": "targets:\n  - rate_limit_reserve: -1",
+		"bad http timeout": "targets:\n  - http:\n      timeout: soon",
+		"no concurrency":   "targets:\n  - http:\n      max_concurrent_requests: -1",
+		"missing ca file":  "targets:\n  - http:\n      ca_file: missing.pem",
+		"cert without key": "targets:\n  - http:\n      cert_file: cert.pem",
+		"two credentials":  "targets:\n  - token: a\n    token_file: b",
+		"token and tokens": "targets:\n  - token: a\n    tokens: [b, c]",
+		"incomplete app":   "targets:\n  - github_app:\n      id: 1",
+	}
+
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := config.LoadFile(path); err == nil {
+				t.Errorf("expected an error loading %q", body)
+			}
+		})
+	}
+}
+
+func TestCheckCollectors(t *testing.T) {
+	cases := map[string]struct {
+		collectors map[string]bool
+		valid      bool
+	}{
+		"defaults":            {map[string]bool{}, true},
+		"disable default":     {map[string]bool{"pull": false}, true},
+		"jobs with actions":   {map[string]bool{"actions": true, "actions_jobs": true}, true},
+		"unknown collector":   {map[string]bool{"nope": true}, false},
+		"jobs without action": {map[string]bool{"actions_jobs": true}, false},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := config.Init()
+			for collector, enabled := range tc.collectors {
+				c.SetCollector(collector, enabled)
+			}
+			if err := exporter.CheckCollectors(c); (err == nil) != tc.valid {
+				t.Errorf("unexpected result checking %v: %v", tc.collectors, err)
+			}
+		})
+	}
+}
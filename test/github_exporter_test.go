@@ -1,23 +1,31 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/githubexporter/github-exporter/config"
 	"github.com/githubexporter/github-exporter/exporter"
 	web "github.com/githubexporter/github-exporter/http"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/steinfletcher/apitest"
 )
 
 func TestHomepage(t *testing.T) {
 	test, collector := apiTest(withConfig("a/b"))
-	defer prometheus.Unregister(&collector)
+	defer prometheus.Unregister(collector)
 
 	test.Get("/").
 		Expect(t).
@@ -28,19 +36,24 @@ func TestHomepage(t *testing.T) {
 
 func TestGithubExporter(t *testing.T) {
 	test, collector := apiTest(withConfig("myOrg/myRepo"))
-	defer prometheus.Unregister(&collector)
+	defer prometheus.Unregister(collector)
 
 	test.Mocks(
 		githubRepos(),
 		githubRateLimit(),
 		githubReleases(),
-		githubPulls(),
+		githubPullSearch(),
 	).
 		Get("/metrics").
 		Expect(t).
-		Assert(bodyContains(`github_rate_limit 60`)).
-		Assert(bodyContains(`github_rate_remaining 60`)).
-		Assert(bodyContains(`github_rate_reset 1.566853865e+09`)).
+		Assert(bodyContains(`github_rate_limit{resource="core",token="5994471a"} 60`)).
+		Assert(bodyContains(`github_rate_remaining{resource="core",token="5994471a"} 60`)).
+		Assert(bodyContains(`github_rate_reset{resource="core",token="5994471a"} 1.566853865e+09`)).
+		Assert(bodyContains(`github_rate_used{resource="core",token="5994471a"} 0`)).
+		Assert(bodyContains(`github_rate_limit{resource="search",token="5994471a"} 30`)).
+		Assert(bodyContains(`github_rate_remaining{resource="search",token="5994471a"} 18`)).
+		Assert(bodyContains(`github_rate_used{resource="search",token="5994471a"} 12`)).
+		Assert(bodyContains(`github_rate_remaining{resource="graphql",token="5994471a"} 4993`)).
 		Assert(bodyContains(`github_repo_forks{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 10`)).
 		Assert(bodyContains(`github_repo_pull_request_count{repo="myRepo",user="myOrg"} 3`)).
 		Assert(bodyContains(`github_repo_open_issues{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 2`)).
@@ -51,28 +64,898 @@ func TestGithubExporter(t *testing.T) {
 		Assert(bodyContains(`github_repo_release_downloads{created_at="2019-02-28T08:25:53Z",name="myRepo_1.3.0_windows_amd64.tar.gz",release="1.3.0",repo="myRepo",tag="1.3.0",user="myOrg"} 21`)).
 		Assert(bodyContains(`github_repo_release_downloads{created_at="2019-05-02T15:22:16Z",name="myRepo_2.0.0_checksums.txt",release="2.0.0",repo="myRepo",tag="2.0.0",user="myOrg"} 14564`)).
 		Assert(bodyContains(`github_repo_release_downloads{created_at="2019-05-02T15:22:16Z",name="myRepo_2.0.0_windows_amd64.tar.gz",release="2.0.0",repo="myRepo",tag="2.0.0",user="myOrg"} 55`)).
+		Assert(bodyContains(`github_exporter_last_refresh_timestamp_seconds `)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Assert(bodyContains(`github_target_scrape_errors_total{target="repo:myOrg/myRepo"} 0`)).
+		Assert(bodyContains(`github_exporter_scrape_success 1`)).
+		Assert(bodyContains(`github_exporter_scrape_duration_seconds `)).
+		Status(http.StatusOK).
+		End()
+
+	// API requests are counted as they are made, so appear from the next scrape
+	apitest.New().
+		Handler(promhttp.Handler()).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_exporter_api_requests_total{endpoint="/repos/:owner/:repo",status_code="200"} `)).
+		Assert(bodyContains(`github_exporter_api_request_duration_seconds_count{endpoint="/repos/:owner/:repo/releases"} `)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterActions(t *testing.T) {
+	_ = os.Setenv("COLLECTOR_ACTIONS", "true")
+	defer os.Unsetenv("COLLECTOR_ACTIONS")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Mocks(
+		githubRepos(),
+		githubRateLimit(),
+		githubReleases(),
+		githubPullSearch(),
+		githubWorkflowRuns(),
+	).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_actions_workflow_runs{branch="master",conclusion="success",event="push",repo="myRepo",status="completed",user="myOrg",workflow="CI"} 2`)).
+		Assert(bodyContains(`github_actions_workflow_runs{branch="feature",conclusion="failure",event="pull_request",repo="myRepo",status="completed",user="myOrg",workflow="CI"} 1`)).
+		Assert(bodyContains(`github_actions_workflow_runs{branch="master",conclusion="",event="push",repo="myRepo",status="in_progress",user="myOrg",workflow="Release"} 1`)).
+		Assert(bodyContains(`github_actions_workflow_run_duration_seconds_bucket{repo="myRepo",user="myOrg",workflow="CI",le="120"} 2`)).
+		Assert(bodyContains(`github_actions_workflow_run_duration_seconds_sum{repo="myRepo",user="myOrg",workflow="CI"} 480`)).
+		Assert(bodyContains(`github_actions_workflow_run_duration_seconds_count{repo="myRepo",user="myOrg",workflow="CI"} 3`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterActionsJobs(t *testing.T) {
+	_ = os.Setenv("COLLECTOR_ACTIONS", "true")
+	_ = os.Setenv("COLLECTOR_ACTIONS_JOBS", "true")
+	_ = os.Setenv("ACTIONS_JOB_RUNS", "1")
+	defer os.Unsetenv("COLLECTOR_ACTIONS")
+	defer os.Unsetenv("COLLECTOR_ACTIONS_JOBS")
+	defer os.Unsetenv("ACTIONS_JOB_RUNS")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Mocks(
+		githubRepos(),
+		githubRateLimit(),
+		githubReleases(),
+		githubPullSearch(),
+		githubWorkflowRuns(),
+		githubWorkflowJobs(),
+	).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_actions_job_queue_duration_seconds_sum{job="build",repo="myRepo",runner_labels="linux,self-hosted",user="myOrg",workflow="CI"} 20`)).
+		Assert(bodyContains(`github_actions_job_queue_duration_seconds_sum{job="lint",repo="myRepo",runner_labels="ubuntu-latest",user="myOrg",workflow="CI"} 2`)).
+		// A job without a creation time has no queue time
+		Assert(bodyContains(`github_actions_job_queue_duration_seconds_count{job="lint",repo="myRepo",runner_labels="ubuntu-latest",user="myOrg",workflow="CI"} 1`)).
+		Assert(bodyContains(`github_actions_job_duration_seconds_sum{job="build",repo="myRepo",runner_labels="linux,self-hosted",user="myOrg",workflow="CI"} 60`)).
+		Assert(bodyContains(`github_actions_step_duration_seconds_sum{job="build",repo="myRepo",step="Test",user="myOrg",workflow="CI"} 55`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterPullLifecycle(t *testing.T) {
+	_ = os.Setenv("COLLECTOR_PULL_LIFECYCLE", "true")
+	_ = os.Setenv("COLLECTOR_PULL_REVIEWS", "true")
+	// The fixtures were closed long ago, and mocks are matched one request at a time
+	_ = os.Setenv("PULLS_LOOKBACK", "876000h")
+	_ = os.Setenv("MAX_CONCURRENT_REQUESTS", "1")
+	defer os.Unsetenv("COLLECTOR_PULL_LIFECYCLE")
+	defer os.Unsetenv("COLLECTOR_PULL_REVIEWS")
+	defer os.Unsetenv("PULLS_LOOKBACK")
+	defer os.Unsetenv("MAX_CONCURRENT_REQUESTS")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Mocks(
+		githubRepos(),
+		githubRateLimit(),
+		githubReleases(),
+		githubClosedPulls(),
+		githubPullSearch(),
+		githubPulls(),
+		githubPullReviews(48, "testdata/pull_reviews_response.json"),
+		githubPullReviews(47, ""),
+	).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_pull_request_open{base="master",draft="false",repo="myRepo",user="myOrg"} 3`)).
+		Assert(bodyContains(`github_pull_request_age_seconds_count{base="master",repo="myRepo",user="myOrg"} 3`)).
+		Assert(bodyContains(`github_pull_request_time_to_merge_seconds_sum{base="master",repo="myRepo",user="myOrg"} 86400`)).
+		Assert(bodyContains(`github_pull_request_time_to_merge_seconds_count{base="master",repo="myRepo",user="myOrg"} 1`)).
+		Assert(bodyContains(`github_pull_request_time_to_first_review_seconds_sum{base="master",repo="myRepo",user="myOrg"} 7200`)).
+		Assert(bodyContains(`github_pull_request_time_to_first_review_seconds_count{base="master",repo="myRepo",user="myOrg"} 1`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterPullCountsBeyondOnePage(t *testing.T) {
+	// The repository has 142 open pull requests, counted by search and listed over two pages of 100, and 15 open issues
+	files := fakeGithubHandler(map[string]string{
+		"/repos/myOrg/busyRepo": "testdata/busy_repo_response.json",
+	})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/myOrg/busyRepo/releases", r.URL.Query().Get("state") == "closed":
+			_, _ = w.Write([]byte("[]"))
+		case r.URL.Path == "/search/issues":
+			_, _ = w.Write([]byte(`{"total_count": 142, "incomplete_results": false, "items": []}`))
+		case r.URL.Path == "/repos/myOrg/busyRepo/pulls":
+			first, last := 1, 100
+			if r.URL.Query().Get("page") == "2" {
+				first, last = 101, 142
+			} else {
+				w.Header().Set("Link", fmt.Sprintf(`<http://%s/repos/myOrg/busyRepo/pulls?per_page=100&page=2>; rel="last"`, r.Host))
+			}
+			pulls := []string{}
+			for n := first; n <= last; n++ {
+				pulls = append(pulls, fmt.Sprintf(`{"number": %d, "base": {"ref": "main"}, "created_at": "2020-03-01T00:00:00Z"}`, n))
+			}
+			fmt.Fprintf(w, "[%s]", strings.Join(pulls, ","))
+		default:
+			files.ServeHTTP(w, r)
+		}
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("COLLECTOR_PULL_LIFECYCLE", "true")
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("COLLECTOR_PULL_LIFECYCLE")
+
+	test, collector := apiTest(withConfig("myOrg/busyRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_repo_pull_request_count{repo="busyRepo",user="myOrg"} 142`)).
+		Assert(bodyContains(`github_repo_open_issues{archived="false",fork="false",language="Go",license="apache-2.0",private="false",repo="busyRepo",user="myOrg"} 15`)).
+		Assert(bodyContains(`github_pull_request_open{base="main",draft="false",repo="busyRepo",user="myOrg"} 142`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/busyRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterIssues(t *testing.T) {
+	_ = os.Setenv("COLLECTOR_ISSUES", "true")
+	_ = os.Setenv("ISSUE_LABELS", "bug, p1")
+	defer os.Unsetenv("COLLECTOR_ISSUES")
+	defer os.Unsetenv("ISSUE_LABELS")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	// The pull request listed by the issues endpoint is not counted, and labels are matched ignoring case.
+	// Closed issues are counted through the search API, as GraphQL is not the configured backend.
+	test.Mocks(
+		githubRepos(),
+		githubRateLimit(),
+		githubReleases(),
+		githubPullSearch(),
+		githubIssues(),
+		githubIssueSearch(`repo:myOrg/myRepo is:issue is:closed`, 40),
+		githubIssueSearch(`repo:myOrg/myRepo is:issue is:closed label:"bug"`, 12),
+		githubIssueSearch(`repo:myOrg/myRepo is:issue is:closed label:"p1"`, 3),
+	).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_repo_issues{label="",repo="myRepo",state="open",user="myOrg"} 3`)).
+		Assert(bodyContains(`github_repo_issues{label="bug",repo="myRepo",state="open",user="myOrg"} 2`)).
+		Assert(bodyContains(`github_repo_issues{label="p1",repo="myRepo",state="open",user="myOrg"} 1`)).
+		Assert(bodyContains(`github_repo_issues{label="",repo="myRepo",state="closed",user="myOrg"} 40`)).
+		Assert(bodyContains(`github_repo_issues{label="bug",repo="myRepo",state="closed",user="myOrg"} 12`)).
+		Assert(bodyContains(`github_repo_issues{label="p1",repo="myRepo",state="closed",user="myOrg"} 3`)).
+		Assert(bodyContains(`github_repo_issues_unassigned{label="",repo="myRepo",user="myOrg"} 2`)).
+		Assert(bodyContains(`github_repo_issues_unassigned{label="bug",repo="myRepo",user="myOrg"} 1`)).
+		Assert(bodyContains(`github_repo_issue_age_seconds_count{label="bug",repo="myRepo",user="myOrg"} 2`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterIssuesGraphQL(t *testing.T) {
+	_ = os.Setenv("API_BACKEND", "graphql")
+	_ = os.Setenv("COLLECTOR_ISSUES", "true")
+	_ = os.Setenv("ISSUE_LABELS", "bug, p1")
+	defer os.Unsetenv("API_BACKEND")
+	defer os.Unsetenv("COLLECTOR_ISSUES")
+	defer os.Unsetenv("ISSUE_LABELS")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo, myOrg/missing"))
+	defer prometheus.Unregister(collector)
+
+	// Closed issues are counted in a single query with the GraphQL backend
+	test.Mocks(
+		githubGraphQL(),
+		githubRateLimit(),
+		githubIssues(),
+		githubClosedIssues(),
+	).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_repo_issues{label="",repo="myRepo",state="open",user="myOrg"} 3`)).
+		Assert(bodyContains(`github_repo_issues{label="",repo="myRepo",state="closed",user="myOrg"} 40`)).
+		Assert(bodyContains(`github_repo_issues{label="bug",repo="myRepo",state="closed",user="myOrg"} 12`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterIssuesWithoutClosedCounts(t *testing.T) {
+	_ = os.Setenv("COLLECTOR_ISSUES", "true")
+	defer os.Unsetenv("COLLECTOR_ISSUES")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	// The open issues are served even though the closed issues could not be counted
+	test.Mocks(
+		githubRepos(),
+		githubRateLimit(),
+		githubReleases(),
+		githubPullSearch(),
+		githubIssues(),
+		githubIssueSearchError(),
+	).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_repo_issues{label="",repo="myRepo",state="open",user="myOrg"} 3`)).
+		Assert(bodyContains(`github_repo_issues_unassigned{label="",repo="myRepo",user="myOrg"} 2`)).
+		Assert(bodyNotContains(`state="closed"`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 0`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterTraffic(t *testing.T) {
+	// Views and clones are reported for yesterday, as today is incomplete
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	yesterday := today.Add(-24 * time.Hour)
+
+	files := fakeGithubHandler(map[string]string{
+		"/repos/myOrg/myRepo":                           "testdata/my_repo_response.json",
+		"/repos/myOrg/myRepo/releases":                  "testdata/releases_response.json",
+		"/search/issues":                                "testdata/search_pulls_response.json",
+		"/repos/myOrg/myRepo/traffic/popular/referrers": "testdata/traffic_referrers_response.json",
+		"/repos/myOrg/myRepo/traffic/popular/paths":     "testdata/traffic_paths_response.json",
+	})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kind := strings.TrimPrefix(r.URL.Path, "/repos/myOrg/myRepo/traffic/")
+		if kind != "views" && kind != "clones" {
+			files.ServeHTTP(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"count": 15, "uniques": 6, "%s": [{"timestamp": "%s", "count": 12, "uniques": 5}, {"timestamp": "%s", "count": 3, "uniques": 2}]}`,
+			kind, yesterday.Format(time.RFC3339), today.Format(time.RFC3339))
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("COLLECTOR_TRAFFIC", "true")
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("COLLECTOR_TRAFFIC")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_repo_traffic_views_daily{repo="myRepo",user="myOrg"} 12`)).
+		Assert(bodyContains(`github_repo_traffic_unique_visitors_daily{repo="myRepo",user="myOrg"} 5`)).
+		Assert(bodyContains(`github_repo_traffic_clones_daily{repo="myRepo",user="myOrg"} 12`)).
+		Assert(bodyContains(`github_repo_traffic_unique_cloners_daily{repo="myRepo",user="myOrg"} 5`)).
+		Assert(bodyContains(`github_repo_traffic_referrer_views{referrer="Google",repo="myRepo",user="myOrg"} 4`)).
+		Assert(bodyContains(`github_repo_traffic_path_unique_visitors{path="/myOrg/myRepo",repo="myRepo",user="myOrg"} 4`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterTrafficForbidden(t *testing.T) {
+	// Traffic requires push access, without which the repository is still served
+	files := fakeGithubHandler(map[string]string{
+		"/repos/myOrg/myRepo":          "testdata/my_repo_response.json",
+		"/repos/myOrg/myRepo/releases": "testdata/releases_response.json",
+		"/search/issues":               "testdata/search_pulls_response.json",
+	})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/repos/myOrg/myRepo/traffic/") {
+			http.Error(w, `{"message": "Must have push access to repository"}`, http.StatusForbidden)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("COLLECTOR_TRAFFIC", "true")
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("COLLECTOR_TRAFFIC")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Assert(bodyContains(`github_repo_stars{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 120`)).
+		Assert(bodyNotContains(`github_repo_traffic_views_daily`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterRunners(t *testing.T) {
+	_ = os.Setenv("COLLECTOR_RUNNERS", "true")
+	defer os.Unsetenv("COLLECTOR_RUNNERS")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Mocks(
+		githubRepos(),
+		githubRateLimit(),
+		githubReleases(),
+		githubPullSearch(),
+		githubRunners(),
+	).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_actions_runner_online{labels="linux,self-hosted",os="linux",runner="linux-runner-2",target="repo:myOrg/myRepo"} 0`)).
+		Assert(bodyContains(`github_actions_runner_busy{labels="linux,self-hosted",os="linux",runner="linux-runner-1",target="repo:myOrg/myRepo"} 1`)).
+		Assert(bodyContains(`github_actions_runners{busy="false",labels="linux,self-hosted",status="online",target="repo:myOrg/myRepo"} 0`)).
+		Assert(bodyContains(`github_actions_runners{busy="false",labels="macos,self-hosted",status="online",target="repo:myOrg/myRepo"} 1`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterPartialFailure(t *testing.T) {
+	// Targets are fetched concurrently, so the API is served by a real server
+	// rather than apitest mocks which are not safe for concurrent use.
+	api := fakeGithubAPI(map[string]string{
+		"/repos/myOrg/myRepo":          "testdata/my_repo_response.json",
+		"/repos/myOrg/myRepo/releases": "testdata/releases_response.json",
+		"/search/issues":               "testdata/search_pulls_response.json",
+	})
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	defer os.Unsetenv("API_URL")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo, myOrg/missing"))
+	defer prometheus.Unregister(collector)
+
+	test.Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_repo_stars{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 120`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/missing"} 0`)).
+		Assert(bodyContains(`github_target_scrape_errors_total{target="repo:myOrg/missing"} 1`)).
+		// The fake API sends no rate limit body, so only the core rate limit is read from the headers
+		Assert(bodyContains(`github_rate_remaining{resource="core",token="5994471a"} 60`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterBoundedConcurrency(t *testing.T) {
+	// The organization's repositories span 5 pages, fetched by at most 2 requests at once
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	requested := map[string]int{}
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Remaining", "60")
+		w.Header().Set("X-RateLimit-Reset", "1566853865")
+		if r.URL.Path == "/rate_limit" {
+			return
+		}
+
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			page = "1"
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/orgs/myOrg/repos?per_page=100&page=5>; rel="last"`, r.Host))
+		}
+		mu.Lock()
+		requested[page]++
+		mu.Unlock()
+		fmt.Fprintf(w, `[{"name": "repo%s", "owner": {"login": "myOrg"}}]`, page)
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("ORGS", "myOrg")
+	_ = os.Setenv("MAX_CONCURRENT_REQUESTS", "2")
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("ORGS")
+	defer os.Unsetenv("MAX_CONCURRENT_REQUESTS")
+
+	test, collector := apiTest(withConfig(""))
+	defer prometheus.Unregister(collector)
+
+	// The pull requests of organisation repositories are only counted through GraphQL
+	test.Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_target_up{target="org:myOrg"} 1`)).
+		Assert(bodyContains(`repo="repo1"`)).
+		Assert(bodyContains(`repo="repo5"`)).
+		Assert(bodyNotContains(`github_repo_pull_request_count`)).
+		Status(http.StatusOK).
+		End()
+
+	if maxInFlight > 2 {
+		t.Errorf("expected at most 2 requests in flight, got %d", maxInFlight)
+	}
+	for page := 1; page <= 5; page++ {
+		if n := requested[fmt.Sprint(page)]; n != 1 {
+			t.Errorf("expected page %d to be requested once, got %d", page, n)
+		}
+	}
+}
+
+func TestGithubExporterBoundedConcurrencyGraphQL(t *testing.T) {
+	// Each of 5 organizations is queried separately, by at most 2 queries at once
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Remaining", "60")
+		w.Header().Set("X-RateLimit-Reset", "1566853865")
+		if r.URL.Path != "/graphql" {
+			return
+		}
+
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		query := struct {
+			Variables struct {
+				Login string `json:"login"`
+			} `json:"variables"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&query)
+		login := query.Variables.Login
+		fmt.Fprintf(w, `{"data": {"owner": {"repositories": {"pageInfo": {"hasNextPage": false}, "nodes": [{"name": "%sRepo", "owner": {"login": "%s"}}]}}}}`, login, login)
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("API_BACKEND", "graphql")
+	_ = os.Setenv("ORGS", "org1, org2, org3, org4, org5")
+	_ = os.Setenv("MAX_CONCURRENT_REQUESTS", "2")
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("API_BACKEND")
+	defer os.Unsetenv("ORGS")
+	defer os.Unsetenv("MAX_CONCURRENT_REQUESTS")
+
+	test, collector := apiTest(withConfig(""))
+	defer prometheus.Unregister(collector)
+
+	test.Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_target_up{target="org:org1"} 1`)).
+		Assert(bodyContains(`repo="org5Repo"`)).
+		Status(http.StatusOK).
+		End()
+
+	if maxInFlight > 2 {
+		t.Errorf("expected at most 2 queries in flight, got %d", maxInFlight)
+	}
+}
+
+func TestGithubExporterGraphQL(t *testing.T) {
+	_ = os.Setenv("API_BACKEND", "graphql")
+	defer os.Unsetenv("API_BACKEND")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo, myOrg/missing"))
+	defer prometheus.Unregister(collector)
+
+	// Both repositories are fetched in a single query, replacing the repo, releases and pulls requests
+	test.Mocks(
+		githubGraphQL(),
+		githubRateLimit(),
+	).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_repo_forks{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 10`)).
+		Assert(bodyContains(`github_repo_pull_request_count{repo="myRepo",user="myOrg"} 3`)).
+		Assert(bodyContains(`github_repo_open_issues{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 2`)).
+		Assert(bodyContains(`github_repo_size_kb{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 946`)).
+		Assert(bodyContains(`github_repo_stars{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 120`)).
+		Assert(bodyContains(`github_repo_watchers{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 5`)).
+		Assert(bodyContains(`github_repo_release_downloads{created_at="2019-02-28T08:25:53Z",name="myRepo_1.3.0_checksums.txt",release="1.3.0",repo="myRepo",tag="1.3.0",user="myOrg"} 7292`)).
+		Assert(bodyContains(`github_repo_release_downloads{created_at="2019-05-02T15:22:16Z",name="myRepo_2.0.0_windows_amd64.tar.gz",release="2.0.0",repo="myRepo",tag="2.0.0",user="myOrg"} 55`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/missing"} 0`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterConditionalRequests(t *testing.T) {
+	// Responses are cached by every exporter, so the repository cached by the first
+	// exporter's refresh is revalidated by the second exporter's refresh with the same token
+	first, collector := apiTest(withConfig("myOrg/myRepo"))
+
+	first.Mocks(
+		githubReposWithETag(),
+		githubRateLimit(),
+		githubReleases(),
+		githubPullSearch(),
+	).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+	prometheus.Unregister(collector)
+
+	second, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	second.Mocks(
+		githubReposNotModified(),
+		githubRateLimit(),
+		githubReleases(),
+		githubPullSearch(),
+	).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_repo_stars{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 120`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+
+	apitest.New().
+		Handler(promhttp.Handler()).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_exporter_api_requests_total{endpoint="/repos/:owner/:repo",status_code="304"} `)).
+		Assert(bodyContains(`github_exporter_http_cache_hits_total{endpoint="/repos/:owner/:repo"} `)).
+		Assert(bodyContains(`github_exporter_http_cache_misses_total{endpoint="/repos/:owner/:repo"} `)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterConditionalRequestsPerToken(t *testing.T) {
+	// A response cached for one token is only revalidated by requests made with the same token
+	var mu sync.Mutex
+	validated := map[string]bool{}
+
+	files := fakeGithubHandler(map[string]string{
+		"/repos/myOrg/myRepo":          "testdata/my_repo_response.json",
+		"/repos/myOrg/myRepo/releases": "testdata/releases_response.json",
+		"/search/issues":               "testdata/search_pulls_response.json",
+	})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/myOrg/myRepo" {
+			files.ServeHTTP(w, r)
+			return
+		}
+		mu.Lock()
+		validated[r.Header.Get("Authorization")] = r.Header.Get("If-None-Match") != ""
+		mu.Unlock()
+
+		w.Header().Set("ETag", `"5ca1ab1e"`)
+		if r.Header.Get("If-None-Match") == `"5ca1ab1e"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	defer os.Unsetenv("API_URL")
+
+	for _, token := range []string{"12345", "67890", "12345"} {
+		conf := withConfig("myOrg/myRepo")
+		conf.SetAPIToken(token)
+
+		test, collector := apiTest(conf)
+		test.Get("/metrics").
+			Expect(t).
+			Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+			Status(http.StatusOK).
+			End()
+		prometheus.Unregister(collector)
+	}
+
+	if validated["token 67890"] {
+		t.Error("expected the response cached for another token not to be revalidated")
+	}
+	if !validated["token 12345"] {
+		t.Error("expected the response cached for the same token to be revalidated")
+	}
+}
+
+func TestGithubExporterRetries(t *testing.T) {
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	// Each failing request is answered once before the successful mocks are reached
+	test.Mocks(
+		githubSecondaryRateLimit(),
+		githubRateLimitUnavailable(),
+		githubRepos(),
+		githubRateLimit(),
+		githubReleases(),
+		githubPullSearch(),
+	).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_repo_stars{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 120`)).
+		Assert(bodyContains(`github_rate_remaining{resource="core",token="5994471a"} 60`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+
+	apitest.New().
+		Handler(promhttp.Handler()).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_exporter_api_retries_total{endpoint="/repos/:owner/:repo",reason="secondary_rate_limit"} `)).
+		Assert(bodyContains(`github_exporter_api_retries_total{endpoint="/rate_limit",reason="server_error"} `)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestReload(t *testing.T) {
+	api := fakeGithubAPI(map[string]string{
+		"/repos/myOrg/myRepo":    "testdata/my_repo_response.json",
+		"/repos/myOrg/otherRepo": "testdata/my_repo_response.json",
+	})
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("COLLECTOR_RELEASE", "false")
+	_ = os.Setenv("COLLECTOR_PULL", "false")
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("COLLECTOR_RELEASE")
+	defer os.Unsetenv("COLLECTOR_PULL")
+
+	exp := &exporter.Exporter{
+		APIMetrics: exporter.AddMetrics(),
+		Config:     withConfig("myOrg/myRepo"),
+	}
+	server := web.NewServer(exp)
+	defer prometheus.Unregister(exp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exp.Start(ctx)
+	waitForSnapshot(t, exp, func(s exporter.Snapshot) bool { return !s.RefreshedAt.IsZero() })
+
+	_ = os.Setenv("REPOS", "myOrg/myRepo, myOrg/otherRepo")
+	_ = os.Setenv("GITHUB_TOKEN", "67890")
+	defer os.Setenv("GITHUB_TOKEN", "12345")
+
+	apitest.New().
+		Handler(server.Handler).
+		Post("/-/reload").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+
+	// The reloaded configuration is used by the refresh the reload triggers
+	waitForSnapshot(t, exp, func(s exporter.Snapshot) bool { return len(s.TargetUp) == 2 })
+	if exp.APIToken() != "67890" {
+		t.Errorf("expected token to be swapped on reload, got %q", exp.APIToken())
+	}
+}
+
+func TestReloadDuringRefresh(t *testing.T) {
+	// The background refresh hangs until the reload has returned
+	files := fakeGithubHandler(map[string]string{
+		"/repos/myOrg/myRepo":    "testdata/my_repo_response.json",
+		"/repos/myOrg/otherRepo": "testdata/my_repo_response.json",
+	})
+	hung := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/myOrg/myRepo" {
+			once.Do(func() {
+				close(hung)
+				<-release
+			})
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("COLLECTOR_RELEASE", "false")
+	_ = os.Setenv("COLLECTOR_PULL", "false")
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("COLLECTOR_RELEASE")
+	defer os.Unsetenv("COLLECTOR_PULL")
+
+	exp := &exporter.Exporter{
+		APIMetrics: exporter.AddMetrics(),
+		Config:     withConfig("myOrg/myRepo"),
+	}
+	server := web.NewServer(exp)
+	defer prometheus.Unregister(exp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exp.Start(ctx)
+	<-hung
+
+	_ = os.Setenv("REPOS", "myOrg/myRepo, myOrg/otherRepo")
+
+	start := time.Now()
+	apitest.New().
+		Handler(server.Handler).
+		Post("/-/reload").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the reload not to wait for the refresh in progress, took %s", elapsed)
+	}
+	close(release)
+
+	// The refresh in progress completes with the previous configuration, the next uses the new one
+	waitForSnapshot(t, exp, func(s exporter.Snapshot) bool { return len(s.TargetUp) == 2 })
+}
+
+func TestReloadRequiresPost(t *testing.T) {
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Get("/-/reload").
+		Expect(t).
+		Status(http.StatusMethodNotAllowed).
+		End()
+}
+
+func TestGithubExporterServesSnapshot(t *testing.T) {
+	exp := &exporter.Exporter{
+		APIMetrics: exporter.AddMetrics(),
+		Config:     withConfig("myOrg/myRepo"),
+	}
+	server := web.NewServer(exp)
+	defer prometheus.Unregister(exp)
+
+	apitest.New().
+		Handler(refreshFirst(exp, server.Handler)).
+		Mocks(
+			githubRepos(),
+			githubRateLimit(),
+			githubReleases(),
+			githubPullSearch(),
+		).
+		Get("/metrics").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+
+	// No mocks are registered, so these metrics can only be served from the snapshot
+	apitest.New().
+		Handler(server.Handler).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_rate_remaining{resource="core",token="5994471a"} 60`)).
+		Assert(bodyContains(`github_repo_pull_request_count{repo="myRepo",user="myOrg"} 3`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterKeepsDataOfFailedTargets(t *testing.T) {
+	// The repository can be scraped by the first refresh, but not by the next
+	files := fakeGithubHandler(map[string]string{
+		"/repos/myOrg/myRepo": "testdata/my_repo_response.json",
+	})
+	var failing atomic.Bool
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() && r.URL.Path == "/repos/myOrg/myRepo" {
+			http.NotFound(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("COLLECTOR_RELEASE", "false")
+	_ = os.Setenv("COLLECTOR_PULL", "false")
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("COLLECTOR_RELEASE")
+	defer os.Unsetenv("COLLECTOR_PULL")
+
+	conf := withConfig("myOrg/myRepo")
+	exp := &exporter.Exporter{
+		APIMetrics: exporter.AddMetrics(),
+		Config:     conf,
+	}
+	server := web.NewServer(exp)
+	defer prometheus.Unregister(exp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exp.Start(ctx)
+
+	waitForSnapshot(t, exp, func(s exporter.Snapshot) bool { return !s.RefreshedAt.IsZero() })
+	succeeded := exp.Snapshot().SucceededAt
+	failing.Store(true)
+	// Timestamps are served in whole seconds, so the next refresh is at least a second later
+	time.Sleep(time.Second)
+	exp.Reload(conf)
+	waitForSnapshot(t, exp, func(s exporter.Snapshot) bool { return !s.TargetUp["repo:myOrg/myRepo"] })
+
+	apitest.New().
+		Handler(server.Handler).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 0`)).
+		Assert(bodyContains(`github_repo_stars{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 120`)).
+		Assert(bodyContains(fmt.Sprintf(`github_exporter_last_refresh_timestamp_seconds %g`, float64(succeeded.Unix())))).
 		Status(http.StatusOK).
 		End()
 }
 
 func TestGithubExporterHttpErrorHandling(t *testing.T) {
 	test, collector := apiTest(withConfig("myOrg/myRepo"))
-	defer prometheus.Unregister(&collector)
+	defer prometheus.Unregister(collector)
 
 	// Test that the exporter returns when an error occurs
-	// Ideally a new gauge should be added to keep track of scrape errors
-	// following prometheus exporter guidelines
 	test.Mocks(
-		githubPullsError(),
+		githubIssueSearchError(),
 	).
 		Get("/metrics").
 		Expect(t).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 0`)).
+		Assert(bodyContains(`github_target_scrape_errors_total{target="repo:myOrg/myRepo"} 1`)).
+		Assert(bodyContains(`github_exporter_scrape_success 0`)).
+		Status(http.StatusOK).
+		End()
+
+	apitest.New().
+		Handler(promhttp.Handler()).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_exporter_api_requests_total{endpoint="/repos/:owner/:repo",status_code="error"} `)).
 		Status(http.StatusOK).
 		End()
 }
 
-func apiTest(conf config.Config) (*apitest.APITest, exporter.Exporter) {
-	exp := exporter.Exporter{
+func apiTest(conf config.Config) (*apitest.APITest, *exporter.Exporter) {
+	exp := &exporter.Exporter{
 		APIMetrics: exporter.AddMetrics(),
 		Config:     conf,
 	}
@@ -80,7 +963,23 @@ func apiTest(conf config.Config) (*apitest.APITest, exporter.Exporter) {
 
 	return apitest.New().
 		Report(apitest.SequenceDiagram()).
-		Handler(server.Handler), exp
+		Handler(refreshFirst(exp, server.Handler)), exp
+}
+
+// refreshFirst serves the handler once the exporter has gathered its first snapshot, as scrapes
+// are only served from it. The refresh runs within the request, so it uses the request's mocks.
+func refreshFirst(exp *exporter.Exporter, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exp.Snapshot().RefreshedAt.IsZero() {
+			ctx, cancel := context.WithCancel(context.Background())
+			go exp.Start(ctx)
+			for deadline := time.Now().Add(5 * time.Second); exp.Snapshot().RefreshedAt.IsZero() && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+			}
+			cancel()
+		}
+		handler.ServeHTTP(w, r)
+	})
 }
 
 func withConfig(repos string) config.Config {
@@ -95,12 +994,34 @@ func githubRepos() *apitest.Mock {
 		Header("Authorization", "token 12345").
 		Query("per_page", "100").
 		RespondWith().
-		Times(2).
 		Body(readFile("testdata/my_repo_response.json")).
 		Status(200).
 		End()
 }
 
+func githubReposWithETag() *apitest.Mock {
+	return apitest.NewMock().
+		Get("https://api.github.com/repos/myOrg/myRepo").
+		Header("Authorization", "token 12345").
+		Query("per_page", "100").
+		RespondWith().
+		Header("ETag", `"5ca1ab1e"`).
+		Body(readFile("testdata/my_repo_response.json")).
+		Status(http.StatusOK).
+		End()
+}
+
+func githubReposNotModified() *apitest.Mock {
+	return apitest.NewMock().
+		Get("https://api.github.com/repos/myOrg/myRepo").
+		Header("Authorization", "token 12345").
+		Header("If-None-Match", `"5ca1ab1e"`).
+		Query("per_page", "100").
+		RespondWith().
+		Status(http.StatusNotModified).
+		End()
+}
+
 func githubRateLimit() *apitest.Mock {
 	return apitest.NewMock().
 		Get("https://api.github.com/rate_limit").
@@ -109,16 +1030,48 @@ func githubRateLimit() *apitest.Mock {
 		Header("X-RateLimit-Limit", "60").
 		Header("X-RateLimit-Remaining", "60").
 		Header("X-RateLimit-Reset", "1566853865").
+		Body(readFile("testdata/rate_limit_response.json")).
 		Status(http.StatusOK).
 		End()
 }
 
+func githubGraphQL() *apitest.Mock {
+	return apitest.NewMock().
+		Post("https://api.github.com/graphql").
+		Header("Authorization", "token 12345").
+		AddMatcher(requestBodyContains(`"name1":"missing"`)).
+		RespondWith().
+		Body(readFile("testdata/graphql_repos_response.json")).
+		Status(http.StatusOK).
+		End()
+}
+
+func githubSecondaryRateLimit() *apitest.Mock {
+	return apitest.NewMock().
+		Get("https://api.github.com/repos/myOrg/myRepo").
+		Header("Authorization", "token 12345").
+		RespondWith().
+		Header("Retry-After", "0").
+		Body(`{"message": "You have exceeded a secondary rate limit. Please wait a few minutes before you try again."}`).
+		Status(http.StatusForbidden).
+		End()
+}
+
+func githubRateLimitUnavailable() *apitest.Mock {
+	return apitest.NewMock().
+		Get("https://api.github.com/rate_limit").
+		Header("Authorization", "token 12345").
+		RespondWith().
+		Header("Retry-After", "0").
+		Status(http.StatusServiceUnavailable).
+		End()
+}
+
 func githubReleases() *apitest.Mock {
 	return apitest.NewMock().
 		Get("https://api.github.com/repos/myOrg/myRepo/releases").
 		Header("Authorization", "token 12345").
 		RespondWith().
-		Times(2).
 		Body(readFile("testdata/releases_response.json")).
 		Status(http.StatusOK).
 		End()
@@ -128,22 +1081,167 @@ func githubPulls() *apitest.Mock {
 	return apitest.NewMock().
 		Get("https://api.github.com/repos/myOrg/myRepo/pulls").
 		Header("Authorization", "token 12345").
+		Query("per_page", "100").
 		RespondWith().
-		Times(2).
 		Body(readFile("testdata/pulls_response.json")).
 		Status(http.StatusOK).
 		End()
 }
 
-func githubPullsError() *apitest.Mock {
+// githubPullSearch counts the open pull requests of myOrg/myRepo, which are listed by githubPulls
+func githubPullSearch() *apitest.Mock {
+	return githubIssueSearch("repo:myOrg/myRepo is:pr is:open", 3)
+}
+
+func githubClosedPulls() *apitest.Mock {
 	return apitest.NewMock().
 		Get("https://api.github.com/repos/myOrg/myRepo/pulls").
 		Header("Authorization", "token 12345").
+		Query("state", "closed").
+		Query("sort", "updated").
+		RespondWith().
+		Body(readFile("testdata/pulls_closed_response.json")).
+		Status(http.StatusOK).
+		End()
+}
+
+// githubPullReviews responds with the reviews of a pull request from the file, or none if it is empty
+func githubPullReviews(number int, file string) *apitest.Mock {
+	body := "[]"
+	if file != "" {
+		body = readFile(file)
+	}
+	return apitest.NewMock().
+		Get(fmt.Sprintf("https://api.github.com/repos/myOrg/myRepo/pulls/%d/reviews", number)).
+		Header("Authorization", "token 12345").
+		RespondWith().
+		Body(body).
+		Status(http.StatusOK).
+		End()
+}
+
+func githubIssues() *apitest.Mock {
+	return apitest.NewMock().
+		Get("https://api.github.com/repos/myOrg/myRepo/issues").
+		Header("Authorization", "token 12345").
+		Query("state", "open").
+		RespondWith().
+		Body(readFile("testdata/issues_response.json")).
+		Status(http.StatusOK).
+		End()
+}
+
+// githubClosedIssues responds to the GraphQL query counting the closed issues of myOrg/myRepo
+func githubClosedIssues() *apitest.Mock {
+	return apitest.NewMock().
+		Post("https://api.github.com/graphql").
+		Header("Authorization", "token 12345").
+		AddMatcher(requestBodyContains(`issues(states: CLOSED, labels: [$label1])`)).
 		RespondWith().
-		Status(http.StatusBadRequest).
+		Body(readFile("testdata/graphql_closed_issues_response.json")).
+		Status(http.StatusOK).
 		End()
 }
 
+// githubIssueSearch responds to a search for the issues matching the query with their count
+func githubIssueSearch(query string, count int) *apitest.Mock {
+	return apitest.NewMock().
+		Get("https://api.github.com/search/issues").
+		Header("Authorization", "token 12345").
+		Query("q", query).
+		RespondWith().
+		Body(fmt.Sprintf(`{"total_count": %d, "incomplete_results": false, "items": []}`, count)).
+		Status(http.StatusOK).
+		End()
+}
+
+// githubIssueSearchError rejects any search for issues
+func githubIssueSearchError() *apitest.Mock {
+	return apitest.NewMock().
+		Get("https://api.github.com/search/issues").
+		RespondWith().
+		Body(`{"message": "Validation Failed"}`).
+		Status(http.StatusUnprocessableEntity).
+		End()
+}
+
+func githubWorkflowRuns() *apitest.Mock {
+	return apitest.NewMock().
+		Get("https://api.github.com/repos/myOrg/myRepo/actions/runs").
+		Header("Authorization", "token 12345").
+		Query("per_page", "100").
+		RespondWith().
+		Body(readFile("testdata/workflow_runs_response.json")).
+		Status(http.StatusOK).
+		End()
+}
+
+func githubWorkflowJobs() *apitest.Mock {
+	return apitest.NewMock().
+		Get("https://api.github.com/repos/myOrg/myRepo/actions/runs/30433642/jobs").
+		Header("Authorization", "token 12345").
+		RespondWith().
+		Body(readFile("testdata/workflow_jobs_response.json")).
+		Status(http.StatusOK).
+		End()
+}
+
+func githubRunners() *apitest.Mock {
+	return apitest.NewMock().
+		Get("https://api.github.com/repos/myOrg/myRepo/actions/runners").
+		Header("Authorization", "token 12345").
+		Query("per_page", "100").
+		RespondWith().
+		Body(readFile("testdata/runners_response.json")).
+		Status(http.StatusOK).
+		End()
+}
+
+// requestBodyContains matches mocked requests whose body contains the substring
+func requestBodyContains(substr string) apitest.Matcher {
+	return func(r *http.Request, _ *apitest.MockRequest) error {
+		if r.Body == nil {
+			return fmt.Errorf("received no body")
+		}
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		if !strings.Contains(string(b), substr) {
+			return fmt.Errorf("received body did not contain %q", substr)
+		}
+		return nil
+	}
+}
+
+// fakeGithubAPI serves the given testdata files by request path, answering
+// 404 for anything else. Rate limit headers are set on every response, unless
+// already set by a handler wrapping it.
+func fakeGithubAPI(files map[string]string) *httptest.Server {
+	return httptest.NewServer(fakeGithubHandler(files))
+}
+
+// fakeGithubHandler serves the given testdata files as fakeGithubAPI does
+func fakeGithubHandler(files map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if w.Header().Get("X-RateLimit-Limit") == "" {
+			w.Header().Set("X-RateLimit-Limit", "60")
+			w.Header().Set("X-RateLimit-Remaining", "60")
+			w.Header().Set("X-RateLimit-Reset", "1566853865")
+		}
+		if r.URL.Path == "/rate_limit" {
+			return
+		}
+		file, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(readFile(file)))
+	})
+}
+
 func readFile(path string) string {
 	bytes, err := os.ReadFile(path)
 	if err != nil {
@@ -165,3 +1263,16 @@ func bodyContains(substr string) func(*http.Response, *http.Request) error {
 		return nil
 	}
 }
+
+func bodyNotContains(substr string) func(*http.Response, *http.Request) error {
+	return func(res *http.Response, req *http.Request) error {
+		bytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			panic(err)
+		}
+		if strings.Contains(string(bytes), substr) {
+			return fmt.Errorf("response contained substring '%s'", substr)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,143 @@
+package test
+
+import (
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGithubExporterCustomCA(t *testing.T) {
+	api := httptest.NewTLSServer(fakeGithubHandler(map[string]string{
+		"/repos/myOrg/myRepo":          "testdata/my_repo_response.json",
+		"/repos/myOrg/myRepo/releases": "testdata/releases_response.json",
+		"/search/issues":               "testdata/search_pulls_response.json",
+	}))
+	defer api.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: api.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("TLS_CA_FILE", caFile)
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("TLS_CA_FILE")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_repo_stars{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 120`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterSharesConnections(t *testing.T) {
+	// Probes send their requests over the connections of the exporter, as they share its transport
+	var connections atomic.Int64
+	api := httptest.NewUnstartedServer(fakeGithubHandler(map[string]string{
+		"/repos/myOrg/myRepo":          "testdata/my_repo_response.json",
+		"/repos/myOrg/myRepo/releases": "testdata/releases_response.json",
+		"/search/issues":               "testdata/search_pulls_response.json",
+	}))
+	api.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	api.StartTLS()
+	defer api.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: api.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("TLS_CA_FILE", caFile)
+	_ = os.Setenv("MAX_CONCURRENT_REQUESTS", "1")
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("TLS_CA_FILE")
+	defer os.Unsetenv("MAX_CONCURRENT_REQUESTS")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+	opened := connections.Load()
+
+	for i := 0; i < 2; i++ {
+		test.Get("/probe").
+			Query("target", "repo:myOrg/myRepo").
+			Expect(t).
+			Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+			Status(http.StatusOK).
+			End()
+	}
+
+	if n := connections.Load(); n != opened {
+		t.Errorf("expected probes to open no connections beyond the %d of the exporter, got %d", opened, n)
+	}
+}
+
+func TestGithubExporterUntrustedCA(t *testing.T) {
+	api := httptest.NewTLSServer(fakeGithubHandler(map[string]string{
+		"/repos/myOrg/myRepo":          "testdata/my_repo_response.json",
+		"/repos/myOrg/myRepo/releases": "testdata/releases_response.json",
+		"/search/issues":               "testdata/search_pulls_response.json",
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	defer os.Unsetenv("API_URL")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 0`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestGithubExporterProxy(t *testing.T) {
+	// The proxy receives requests for the unresolvable API host and answers them itself
+	proxy := fakeGithubAPI(map[string]string{
+		"/repos/myOrg/myRepo":          "testdata/my_repo_response.json",
+		"/repos/myOrg/myRepo/releases": "testdata/releases_response.json",
+		"/search/issues":               "testdata/search_pulls_response.json",
+	})
+	defer proxy.Close()
+
+	_ = os.Setenv("API_URL", "http://github.invalid")
+	_ = os.Setenv("PROXY_URL", proxy.URL)
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("PROXY_URL")
+
+	test, collector := apiTest(withConfig("myOrg/myRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_repo_stars{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 120`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+}
@@ -0,0 +1,300 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/githubexporter/github-exporter/config"
+	"github.com/githubexporter/github-exporter/exporter"
+	web "github.com/githubexporter/github-exporter/http"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/steinfletcher/apitest"
+)
+
+func TestGithubExporterAppInstallations(t *testing.T) {
+	// The App is installed on two organizations, each scraped with its own installation token
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "15000")
+		w.Header().Set("X-RateLimit-Remaining", "15000")
+		w.Header().Set("X-RateLimit-Reset", "1566853865")
+
+		switch {
+		case r.URL.Path == "/app/installations" && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "):
+			fmt.Fprint(w, `[{"id": 1, "account": {"login": "orgA"}}, {"id": 2, "account": {"login": "orgB"}}]`)
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/app/installations/"):
+			id := strings.Split(r.URL.Path, "/")[3]
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"token": "installation-%s", "expires_at": "2099-01-01T00:00:00Z"}`, id)
+		case r.URL.Path == "/installation/repositories":
+			account := map[string]string{"token installation-1": "orgA", "token installation-2": "orgB"}[r.Header.Get("Authorization")]
+			if account == "" {
+				http.Error(w, "Bad credentials", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintf(w, `{"total_count": 1, "repositories": [{"name": "%sRepo", "owner": {"login": "%s"}, "stargazers_count": 7}]}`, account, account)
+		case r.URL.Path == "/graphql":
+			fmt.Fprint(w, `{"data": {"r0": {"pullRequests": {"totalCount": 2}}}}`)
+		}
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("GITHUB_APP", "true")
+	_ = os.Setenv("GITHUB_APP_ID", "42")
+	_ = os.Setenv("GITHUB_APP_KEY_PATH", writeAppKey(t))
+	_ = os.Setenv("REPOS", "")
+	_ = os.Setenv("GITHUB_TOKEN", "")
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("GITHUB_APP")
+	defer os.Unsetenv("GITHUB_APP_ID")
+	defer os.Unsetenv("GITHUB_APP_KEY_PATH")
+	defer os.Setenv("GITHUB_TOKEN", "12345")
+
+	defer os.Unsetenv("API_BACKEND")
+
+	// Open pull requests are only counted for installations when the GraphQL API is configured,
+	// as the REST API only lists those of the repositories in REPOS
+	for backend, pulls := range map[string]string{config.BackendREST: "", config.BackendGraphQL: "2"} {
+		t.Run(backend, func(t *testing.T) {
+			_ = os.Setenv("API_BACKEND", backend)
+
+			configs, err := config.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(configs) != 2 {
+				t.Fatalf("expected a configuration for each installation, got %d", len(configs))
+			}
+
+			// Each installation is named after its account, which labels its metrics as the source.
+			// The default registry already holds unlabelled metrics, so each is served from its own.
+			for i, account := range []string{"orgA", "orgB"} {
+				if configs[i].Name() != account || configs[i].Installation() != account {
+					t.Errorf("expected installation %d to be named %s, got %q", i, account, configs[i].Name())
+				}
+
+				exp := &exporter.Exporter{
+					APIMetrics: exporter.AddMetrics(),
+					Config:     configs[i],
+				}
+				registry := prometheus.NewRegistry()
+				registry.MustRegister(exp)
+
+				test := apitest.New().
+					Handler(refreshFirst(exp, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))).
+					Get("/metrics").
+					Expect(t).
+					Assert(bodyContains(`github_target_up{target="installation:` + account + `"} 1`)).
+					Assert(bodyContains(`repo="` + account + `Repo",user="` + account + `"} 7`)).
+					Assert(bodyContains(`github_exporter_token_expiry_timestamp_seconds 4.0709088e+09`))
+				if pulls != "" {
+					test = test.Assert(bodyContains(`github_repo_pull_request_count{repo="` + account + `Repo",user="` + account + `"} ` + pulls))
+				}
+				test.Status(http.StatusOK).End()
+			}
+		})
+	}
+
+	apitest.New().
+		Handler(promhttp.Handler()).
+		Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_exporter_api_requests_total{endpoint="/installation/repositories",status_code="200"} `)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestDiscoverInstallations(t *testing.T) {
+	// Installation targets are labelled by source, which the metrics other tests registered without
+	// it in the default registry cannot be registered alongside, so the test runs in its own process
+	if os.Getenv("TEST_DISCOVER_INSTALLATIONS") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestDiscoverInstallations$")
+		cmd.Env = append(os.Environ(), "TEST_DISCOVER_INSTALLATIONS=true")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		return
+	}
+
+	// The App is installed on orgA and orgB, then uninstalled from orgA and installed on orgC
+	var mu sync.Mutex
+	accounts := map[int]string{1: "orgA", 2: "orgB"}
+	tokens := map[string]int{}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "15000")
+		w.Header().Set("X-RateLimit-Remaining", "15000")
+		w.Header().Set("X-RateLimit-Reset", "1566853865")
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/app/installations":
+			installations := []string{}
+			for id, login := range accounts {
+				installations = append(installations, fmt.Sprintf(`{"id": %d, "account": {"login": %q}}`, id, login))
+			}
+			fmt.Fprintf(w, "[%s]", strings.Join(installations, ","))
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/app/installations/"):
+			id := strings.Split(r.URL.Path, "/")[3]
+			tokens[id]++
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"token": "installation-%s", "expires_at": "2099-01-01T00:00:00Z"}`, id)
+		default:
+			fmt.Fprint(w, "[]")
+		}
+	}))
+	defer api.Close()
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("GITHUB_APP", "true")
+	_ = os.Setenv("GITHUB_APP_ID", "42")
+	_ = os.Setenv("GITHUB_APP_KEY_PATH", writeAppKey(t))
+	_ = os.Setenv("REPOS", "")
+	_ = os.Setenv("GITHUB_TOKEN", "")
+
+	configs, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporters := []*exporter.Exporter{}
+	for _, c := range configs {
+		exporters = append(exporters, &exporter.Exporter{
+			APIMetrics: exporter.AddMetrics(),
+			Config:     c,
+		})
+	}
+	server := web.NewServer(exporters...)
+
+	mu.Lock()
+	accounts = map[int]string{2: "orgB", 3: "orgC"}
+	mu.Unlock()
+	if err := server.DiscoverInstallations(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens are obtained when installations are first scraped, rather than each time they are listed
+	mu.Lock()
+	if len(tokens) != 0 {
+		t.Errorf("expected no installation tokens to be issued by discovery, got %v", tokens)
+	}
+	mu.Unlock()
+
+	for module, status := range map[string]int{"orgA": http.StatusBadRequest, "orgB": http.StatusOK, "orgC": http.StatusOK} {
+		apitest.New().
+			Handler(server.Handler).
+			Get("/probe").
+			Query("module", module).
+			Query("target", "org:"+module).
+			Expect(t).
+			Status(status).
+			End()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if tokens["1"] != 0 || tokens["2"] != 1 || tokens["3"] != 1 {
+		t.Errorf("expected a token to be issued for each installation probed, got %v", tokens)
+	}
+}
+
+func TestGithubExporterAppTokenRenewal(t *testing.T) {
+	tests := map[string]struct {
+		lifetime time.Duration
+		tokens   int
+	}{
+		// Tokens are obtained by the first refresh, and renewed by the next once they would
+		// expire within the refresh interval, or 5 minutes
+		"expiring token": {lifetime: 2 * time.Minute, tokens: 2},
+		"valid token":    {lifetime: time.Hour, tokens: 1},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			tokens := 0
+			expiresAt := time.Now().Add(tt.lifetime).UTC().Truncate(time.Second)
+
+			files := fakeGithubHandler(map[string]string{
+				"/repos/myOrg/myRepo":          "testdata/my_repo_response.json",
+				"/repos/myOrg/myRepo/releases": "testdata/releases_response.json",
+				"/search/issues":               "testdata/search_pulls_response.json",
+			})
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/app/installations/1/access_tokens" {
+					files.ServeHTTP(w, r)
+					return
+				}
+				mu.Lock()
+				tokens++
+				mu.Unlock()
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, `{"token": "installation-1", "expires_at": "%s"}`, expiresAt.Format(time.RFC3339))
+			}))
+			defer api.Close()
+
+			_ = os.Setenv("API_URL", api.URL)
+			_ = os.Setenv("GITHUB_APP", "true")
+			_ = os.Setenv("GITHUB_APP_ID", "42")
+			_ = os.Setenv("GITHUB_APP_INSTALLATION_ID", "1")
+			_ = os.Setenv("GITHUB_APP_KEY_PATH", writeAppKey(t))
+			_ = os.Setenv("GITHUB_TOKEN", "")
+			defer os.Unsetenv("API_URL")
+			defer os.Unsetenv("GITHUB_APP")
+			defer os.Unsetenv("GITHUB_APP_ID")
+			defer os.Unsetenv("GITHUB_APP_INSTALLATION_ID")
+			defer os.Unsetenv("GITHUB_APP_KEY_PATH")
+			defer os.Setenv("GITHUB_TOKEN", "12345")
+
+			_ = os.Setenv("REPOS", "myOrg/myRepo")
+			test, collector := apiTest(config.Init())
+			defer prometheus.Unregister(collector)
+
+			test.Get("/metrics").
+				Expect(t).
+				Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+				Assert(bodyContains(fmt.Sprintf(`github_exporter_token_expiry_timestamp_seconds %g`, float64(expiresAt.Unix())))).
+				Status(http.StatusOK).
+				End()
+
+			refreshed := collector.Snapshot().RefreshedAt
+			ctx, cancel := context.WithCancel(context.Background())
+			go collector.Start(ctx)
+			waitForSnapshot(t, collector, func(s exporter.Snapshot) bool { return s.RefreshedAt.After(refreshed) })
+			cancel()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if tokens != tt.tokens {
+				t.Errorf("expected %d installation tokens to be issued, got %d", tt.tokens, tokens)
+			}
+		})
+	}
+}
+
+// writeAppKey writes a new GitHub App private key, returning its path
+func writeAppKey(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "app.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,115 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/githubexporter/github-exporter/exporter"
+	web "github.com/githubexporter/github-exporter/http"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinfletcher/apitest"
+)
+
+func TestProbe(t *testing.T) {
+	// The repository probed is not among those scraped in the background
+	test, collector := apiTest(withConfig("otherOrg/otherRepo"))
+	defer prometheus.Unregister(collector)
+
+	test.Mocks(
+		githubRepos(),
+		githubRateLimit(),
+		githubReleases(),
+		githubPullSearch(),
+	).
+		Get("/probe").
+		Query("target", "repo:myOrg/myRepo").
+		Expect(t).
+		Assert(bodyContains(`github_repo_stars{archived="false",fork="false",language="Go",license="mit",private="false",repo="myRepo",user="myOrg"} 120`)).
+		Assert(bodyContains(`github_repo_pull_request_count{repo="myRepo",user="myOrg"} 3`)).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Assert(bodyContains(`github_exporter_scrape_success 1`)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestProbeDuringRefresh(t *testing.T) {
+	// The background refresh hangs on the configured repository, while the probed one answers at once
+	files := fakeGithubHandler(map[string]string{
+		"/repos/myOrg/myRepo":          "testdata/my_repo_response.json",
+		"/repos/myOrg/myRepo/releases": "testdata/releases_response.json",
+		"/search/issues":               "testdata/search_pulls_response.json",
+	})
+	hung := make(chan struct{})
+	release := make(chan struct{})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/otherOrg/otherRepo" {
+			close(hung)
+			<-release
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer api.Close()
+	defer close(release)
+
+	_ = os.Setenv("API_URL", api.URL)
+	defer os.Unsetenv("API_URL")
+
+	exp := &exporter.Exporter{
+		APIMetrics: exporter.AddMetrics(),
+		Config:     withConfig("otherOrg/otherRepo"),
+	}
+	server := web.NewServer(exp)
+	defer prometheus.Unregister(exp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exp.Start(ctx)
+	<-hung
+
+	start := time.Now()
+	apitest.New().
+		Handler(server.Handler).
+		Get("/probe").
+		Query("target", "repo:myOrg/myRepo").
+		Header("X-Prometheus-Scrape-Timeout-Seconds", "2").
+		Expect(t).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Status(http.StatusOK).
+		End()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the probe not to wait for the background refresh, took %s", elapsed)
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	exp := &exporter.Exporter{
+		APIMetrics: exporter.AddMetrics(),
+		Config:     withConfig("myOrg/myRepo"),
+	}
+	server := web.NewServer(exp)
+	defer prometheus.Unregister(exp)
+
+	tests := map[string]map[string]string{
+		"missing target":   {},
+		"malformed target": {"target": "repo:myRepo"},
+		"unknown kind":     {"target": "team:myOrg/myTeam"},
+		"unknown module":   {"target": "org:myOrg", "module": "missing"},
+	}
+
+	for name, query := range tests {
+		t.Run(name, func(t *testing.T) {
+			apitest.New().
+				Handler(server.Handler).
+				Get("/probe").
+				QueryParams(query).
+				Expect(t).
+				Status(http.StatusBadRequest).
+				End()
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/githubexporter/github-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGithubExporterTokenPool(t *testing.T) {
+	// The first token is exhausted, so requests refused with it are sent again with the second
+	// at once, rather than after waiting for its rate limit to reset within the refresh interval
+	var mu sync.Mutex
+	remaining := map[string]int{"token exhausted": 0, "token spare": 5000}
+	reset := strconv.FormatInt(time.Now().Add(30*time.Second).Unix(), 10)
+
+	files := fakeGithubHandler(map[string]string{
+		"/repos/myOrg/myRepo":          "testdata/my_repo_response.json",
+		"/repos/myOrg/myRepo/releases": "testdata/releases_response.json",
+		"/search/issues":               "testdata/search_pulls_response.json",
+	})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auth := r.Header.Get("Authorization")
+		left := remaining[auth]
+		if left > 0 && r.URL.Path != "/rate_limit" {
+			remaining[auth]--
+		}
+		mu.Unlock()
+
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(left))
+		w.Header().Set("X-RateLimit-Reset", reset)
+		switch {
+		case r.URL.Path == "/rate_limit":
+		case left == 0:
+			http.Error(w, `{"message": "API rate limit exceeded"}`, http.StatusForbidden)
+		default:
+			files.ServeHTTP(w, r)
+		}
+	}))
+	defer api.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(tokenFile, []byte("exhausted\nspare\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.Setenv("API_URL", api.URL)
+	_ = os.Setenv("REPOS", "myOrg/myRepo")
+	_ = os.Setenv("GITHUB_TOKEN", "")
+	_ = os.Setenv("GITHUB_TOKEN_FILE", tokenFile)
+	defer os.Unsetenv("API_URL")
+	defer os.Unsetenv("GITHUB_TOKEN_FILE")
+	defer os.Setenv("GITHUB_TOKEN", "12345")
+
+	conf := config.Init()
+	if len(conf.APITokens()) != 2 {
+		t.Fatalf("expected a token from each line of the token file, got %d", len(conf.APITokens()))
+	}
+
+	test, collector := apiTest(conf)
+	defer prometheus.Unregister(collector)
+
+	start := time.Now()
+	test.Get("/metrics").
+		Expect(t).
+		Assert(bodyContains(`github_target_up{target="repo:myOrg/myRepo"} 1`)).
+		Assert(bodyContains(`github_repo_pull_request_count{repo="myRepo",user="myOrg"} 3`)).
+		Assert(bodyContains(`github_rate_remaining{resource="core",token="` + fingerprint("exhausted") + `"} 0`)).
+		Assert(bodyContains(`github_rate_remaining{resource="core",token="` + fingerprint("spare") + `"} 4997`)).
+		Status(http.StatusOK).
+		End()
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the exhausted token to be rotated without waiting for its reset, took %s", elapsed)
+	}
+}
+
+// fingerprint returns the fingerprint identifying a token in metrics
+func fingerprint(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))[:8]
+}
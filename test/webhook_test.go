@@ -0,0 +1,147 @@
+package test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/githubexporter/github-exporter/exporter"
+	web "github.com/githubexporter/github-exporter/http"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinfletcher/apitest"
+)
+
+func TestWebhook(t *testing.T) {
+	_ = os.Setenv("WEBHOOK_SECRET", "s3cret")
+	defer os.Unsetenv("WEBHOOK_SECRET")
+
+	exp := &exporter.Exporter{
+		APIMetrics: exporter.AddMetrics(),
+		Config:     withConfig("myOrg/myRepo"),
+	}
+	server := web.NewServer(exp)
+	// Only the webhook metrics are gathered, so the exporter is not needed in the registry
+	prometheus.Unregister(exp)
+
+	// The webhook metrics are shared by every exporter, so only their increase is asserted
+	expected := []struct {
+		name   string
+		labels map[string]string
+		delta  float64
+	}{
+		{"github_webhook_events_total", map[string]string{"event": "push", "action": "", "repo": "myRepo", "user": "myOrg"}, 1},
+		{"github_webhook_events_total", map[string]string{"event": "pull_request", "action": "closed", "repo": "myRepo", "user": "myOrg"}, 1},
+		{"github_webhook_push_commits_total", map[string]string{"repo": "myRepo", "user": "myOrg"}, 2},
+		{"github_webhook_pull_request_lead_time_seconds", map[string]string{"repo": "myRepo", "user": "myOrg"}, 7200},
+		{"github_webhook_workflow_runs_total", map[string]string{"repo": "myRepo", "user": "myOrg", "workflow": "Build", "conclusion": "success"}, 1},
+		{"github_webhook_workflow_run_duration_seconds", map[string]string{"repo": "myRepo", "user": "myOrg", "workflow": "Build"}, 180},
+		{"github_webhook_releases_published_total", map[string]string{"repo": "myRepo", "user": "myOrg"}, 1},
+		{"github_webhook_issues_total", map[string]string{"repo": "myRepo", "user": "myOrg", "action": "closed"}, 1},
+		{"github_webhook_issue_time_to_close_seconds", map[string]string{"repo": "myRepo", "user": "myOrg"}, 86400},
+	}
+	before := make([]float64, len(expected))
+	for i, m := range expected {
+		before[i] = metricValue(t, m.name, m.labels)
+	}
+
+	for event, file := range map[string]string{
+		"push":         "testdata/webhook_push.json",
+		"pull_request": "testdata/webhook_pull_request.json",
+		"workflow_run": "testdata/webhook_workflow_run.json",
+		"release":      "testdata/webhook_release.json",
+		"issues":       "testdata/webhook_issues.json",
+	} {
+		payload := readFile(file)
+		apitest.New().
+			Handler(server.Handler).
+			Post("/webhook").
+			Header("X-GitHub-Event", event).
+			Header("X-Hub-Signature-256", sign("s3cret", payload)).
+			Body(payload).
+			Expect(t).
+			Status(http.StatusNoContent).
+			End()
+	}
+
+	// Deliveries signed with another secret, or not signed, are rejected without being counted
+	for _, signature := range []string{sign("wrong", readFile("testdata/webhook_push.json")), ""} {
+		apitest.New().
+			Handler(server.Handler).
+			Post("/webhook").
+			Header("X-GitHub-Event", "push").
+			Header("X-Hub-Signature-256", signature).
+			Body(readFile("testdata/webhook_push.json")).
+			Expect(t).
+			Status(http.StatusUnauthorized).
+			End()
+	}
+
+	for i, m := range expected {
+		if delta := metricValue(t, m.name, m.labels) - before[i]; delta != m.delta {
+			t.Errorf("expected %s%v to increase by %v, got %v", m.name, m.labels, m.delta, delta)
+		}
+	}
+}
+
+func TestWebhookDisabled(t *testing.T) {
+	exp := &exporter.Exporter{
+		APIMetrics: exporter.AddMetrics(),
+		Config:     withConfig("myOrg/myRepo"),
+	}
+	server := web.NewServer(exp)
+	defer prometheus.Unregister(exp)
+
+	// Without a secret the endpoint is not served, so falls through to the index page
+	apitest.New().
+		Handler(server.Handler).
+		Post("/webhook").
+		Header("X-GitHub-Event", "push").
+		Body(readFile("testdata/webhook_push.json")).
+		Expect(t).
+		Assert(bodyContains(`GitHub Prometheus Metrics Exporter`)).
+		End()
+}
+
+// sign returns the X-Hub-Signature-256 header GitHub sends with a payload
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// metricValue returns the value of a counter, or the sum of a histogram, with exactly the given
+// labels in the default registry, or zero if it has not been observed yet
+func metricValue(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if len(m.GetLabel()) != len(labels) {
+				continue
+			}
+			matches := true
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; !ok || v != l.GetValue() {
+					matches = false
+				}
+			}
+			if !matches {
+				continue
+			}
+			if h := m.GetHistogram(); h != nil {
+				return h.GetSampleSum()
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}